@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestAuthenticate(t *testing.T) {
+	app := &App{
+		auth: &authCfg{Username: "listmonk", Password: "secret", BearerToken: "token"},
+		opts: map[string]msgrOpts{
+			"ses":    {auth: &authCfg{Username: "ses", Password: "ses-secret", HMACSecret: "key"}},
+			"twilio": {auth: &authCfg{}},
+		},
+	}
+
+	r := chi.NewRouter()
+	r.With(authenticate(app)).Post("/webhook/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, c := range []struct {
+		name     string
+		provider string
+		setup    func(r *http.Request)
+		want     int
+	}{
+		{"no credentials", "pinpoint", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"basic auth", "pinpoint", func(r *http.Request) { r.SetBasicAuth("listmonk", "secret") }, http.StatusOK},
+		{"wrong password", "pinpoint", func(r *http.Request) { r.SetBasicAuth("listmonk", "nope") }, http.StatusUnauthorized},
+		{"bearer token", "pinpoint", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"empty override uses global", "twilio", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"global creds on override", "ses", func(r *http.Request) { r.SetBasicAuth("listmonk", "secret") }, http.StatusUnauthorized},
+		{"missing signature", "ses", func(r *http.Request) { r.SetBasicAuth("ses", "ses-secret") }, http.StatusUnauthorized},
+		{"valid signature", "ses", func(r *http.Request) {
+			r.SetBasicAuth("ses", "ses-secret")
+			r.Header.Set("X-Signature", sign(`{"subject":"hi"}`))
+		}, http.StatusOK},
+		{"tampered body", "ses", func(r *http.Request) {
+			r.SetBasicAuth("ses", "ses-secret")
+			r.Header.Set("X-Signature", sign(`{"subject":"bye"}`))
+		}, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/"+c.provider, strings.NewReader(`{"subject":"hi"}`))
+		c.setup(req)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+}
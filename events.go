@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/joeirimpan/listmonk-messenger/internal/sns"
+)
+
+// eventsCfg is the config of an inbound events endpoint fed by SNS.
+type eventsCfg struct {
+	Enabled bool `koanf:"enabled"`
+	// SkipVerify disables SNS signature verification. Only for testing.
+	SkipVerify bool `koanf:"skip_verify"`
+	// CertFile and CertHost configure where signing certificates come from.
+	// See sns.VerifierOpt.
+	CertFile string `koanf:"cert_file"`
+	CertHost string `koanf:"cert_host"`
+	// TopicARNs, if set, are the only topics messages are accepted from.
+	TopicARNs []string `koanf:"topic_arns"`
+}
+
+// sesEvents is the endpoint receiving SES notifications.
+type sesEvents struct {
+	cfg      eventsCfg
+	verifier *sns.Verifier
+}
+
+// sesEvent is an SES bounce, complaint or delivery notification, either from
+// identity notifications (notificationType) or configuration set event
+// publishing (eventType).
+type sesEvent struct {
+	NotificationType string          `json:"notificationType"`
+	EventType        string          `json:"eventType"`
+	Bounce           json.RawMessage `json:"bounce"`
+	Complaint        json.RawMessage `json:"complaint"`
+	Mail             struct {
+		MessageID string              `json:"messageId"`
+		Tags      map[string][]string `json:"tags"`
+		Headers   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"headers"`
+	} `json:"mail"`
+}
+
+type sesBounce struct {
+	BounceType        string `json:"bounceType"`
+	BouncedRecipients []struct {
+		EmailAddress string `json:"emailAddress"`
+	} `json:"bouncedRecipients"`
+}
+
+type sesComplaint struct {
+	ComplainedRecipients []struct {
+		EmailAddress string `json:"emailAddress"`
+	} `json:"complainedRecipients"`
+}
+
+// handleSESEvent receives SES notifications published to an SNS topic and
+// records bounces and complaints in listmonk.
+func handleSESEvent(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	m, ok := readSNSMessage(w, r, app.sesEvents.cfg, app.sesEvents.verifier)
+	if !ok {
+		return
+	}
+	if m.Type != sns.TypeNotification {
+		sendResponse(w, true)
+		return
+	}
+
+	var ev sesEvent
+	if err := json.Unmarshal([]byte(m.Message), &ev); err != nil {
+		sendErrorResponse(w, "invalid SES notification", http.StatusBadRequest, nil)
+		return
+	}
+
+	typ := strings.ToLower(ev.NotificationType + ev.EventType)
+	metricEvents.WithLabelValues("ses", typ).Inc()
+
+	bounces, err := sesBounces(ev)
+	if err != nil {
+		sendErrorResponse(w, "invalid SES notification", http.StatusBadRequest, nil)
+		return
+	}
+	for _, b := range bounces {
+		if err := app.listmonk.RecordBounce(r.Context(), b); err != nil {
+			app.logger.ErrorWith("error recording bounce").String("email", b.Email).Err("err", err).Write()
+
+			// Fail so that SNS retries the notification, unless listmonk
+			// rejected the bounce, eg. for an unknown subscriber.
+			if listmonk.IsTemporary(err) {
+				sendErrorResponse(w, "error recording bounce", http.StatusInternalServerError, nil)
+				return
+			}
+		}
+	}
+
+	sendResponse(w, true)
+}
+
+// sesBounces returns the listmonk bounces of an SES event. Deliveries and
+// other events have none.
+func sesBounces(ev sesEvent) ([]listmonk.Bounce, error) {
+	var (
+		typ  string
+		meta json.RawMessage
+		rcpt []string
+	)
+	switch {
+	case ev.NotificationType == "Bounce" || ev.EventType == "Bounce":
+		var b sesBounce
+		if err := json.Unmarshal(ev.Bounce, &b); err != nil {
+			return nil, err
+		}
+		typ = listmonk.BounceSoft
+		if b.BounceType == "Permanent" {
+			typ = listmonk.BounceHard
+		}
+		for _, r := range b.BouncedRecipients {
+			rcpt = append(rcpt, r.EmailAddress)
+		}
+		meta = ev.Bounce
+
+	case ev.NotificationType == "Complaint" || ev.EventType == "Complaint":
+		var c sesComplaint
+		if err := json.Unmarshal(ev.Complaint, &c); err != nil {
+			return nil, err
+		}
+		typ = listmonk.BounceComplaint
+		for _, r := range c.ComplainedRecipients {
+			rcpt = append(rcpt, r.EmailAddress)
+		}
+		meta = ev.Complaint
+
+	default:
+		return nil, nil
+	}
+
+	// Messages are sent to a single subscriber, whose UUID is in the message
+	// tags with SES v2 or in the listmonk headers, if SES includes them.
+	subUUID := ev.tag("subscriber_uuid", "X-Listmonk-Subscriber")
+	if len(rcpt) > 1 {
+		subUUID = ""
+	}
+	campUUID := ev.tag("campaign_uuid", "X-Listmonk-Campaign")
+
+	out := make([]listmonk.Bounce, 0, len(rcpt))
+	for _, email := range rcpt {
+		out = append(out, listmonk.Bounce{
+			Email:          email,
+			SubscriberUUID: subUUID,
+			CampaignUUID:   campUUID,
+			Source:         "ses",
+			Type:           typ,
+			Meta:           meta,
+		})
+	}
+	return out, nil
+}
+
+// tag returns the value of the message tag or, failing that, of the header.
+func (ev sesEvent) tag(tag, header string) string {
+	if v := ev.Mail.Tags[tag]; len(v) > 0 {
+		return v[0]
+	}
+	for _, h := range ev.Mail.Headers {
+		if strings.EqualFold(h.Name, header) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// readSNSMessage decodes and verifies the SNS message in the request and
+// confirms subscriptions. On failure, it writes the error response and
+// returns false.
+func readSNSMessage(w http.ResponseWriter, r *http.Request, cfg eventsCfg, v *sns.Verifier) (sns.Message, bool) {
+	app := r.Context().Value("app").(*App)
+
+	m, err := sns.Parse(r.Body)
+	if err != nil {
+		sendErrorResponse(w, "invalid SNS message", http.StatusBadRequest, nil)
+		return m, false
+	}
+
+	if len(cfg.TopicARNs) > 0 && !inList(m.TopicARN, cfg.TopicARNs) {
+		sendErrorResponse(w, "unknown topic", http.StatusForbidden, nil)
+		return m, false
+	}
+
+	if !cfg.SkipVerify {
+		if err := v.Verify(r.Context(), m); err != nil {
+			app.logger.ErrorWith("error verifying SNS message").String("topic", m.TopicARN).Err("err", err).Write()
+			if errors.Is(err, sns.ErrInvalidSignature) {
+				sendErrorResponse(w, "invalid signature", http.StatusForbidden, nil)
+			} else {
+				sendErrorResponse(w, "error verifying signature", http.StatusInternalServerError, nil)
+			}
+			return m, false
+		}
+	}
+
+	switch m.Type {
+	case sns.TypeSubscriptionConfirmation:
+		if err := v.Confirm(r.Context(), m); err != nil {
+			app.logger.ErrorWith("error confirming SNS subscription").String("topic", m.TopicARN).Err("err", err).Write()
+			sendErrorResponse(w, "error confirming subscription", http.StatusInternalServerError, nil)
+			return m, false
+		}
+		app.logger.InfoWith("confirmed SNS subscription").String("topic", m.TopicARN).Write()
+	case sns.TypeUnsubscribeConfirmation:
+		app.logger.InfoWith("SNS subscription removed").String("topic", m.TopicARN).Write()
+	}
+
+	return m, true
+}
+
+func inList(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
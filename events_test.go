@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+)
+
+func TestSESBounces(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		event string
+		want  []listmonk.Bounce
+	}{
+		{
+			name: "permanent bounce",
+			event: `{"notificationType": "Bounce",
+				"bounce": {"bounceType": "Permanent", "bouncedRecipients": [{"emailAddress": "a@example.com"}]},
+				"mail": {"tags": {"subscriber_uuid": ["sub"], "campaign_uuid": ["camp"]}}}`,
+			want: []listmonk.Bounce{{Email: "a@example.com", SubscriberUUID: "sub", CampaignUUID: "camp", Source: "ses", Type: listmonk.BounceHard}},
+		},
+		{
+			name: "transient bounce from event publishing",
+			event: `{"eventType": "Bounce",
+				"bounce": {"bounceType": "Transient", "bouncedRecipients": [{"emailAddress": "a@example.com"}]},
+				"mail": {"headers": [{"name": "X-Listmonk-Campaign", "value": "camp"}]}}`,
+			want: []listmonk.Bounce{{Email: "a@example.com", CampaignUUID: "camp", Source: "ses", Type: listmonk.BounceSoft}},
+		},
+		{
+			name: "complaint",
+			event: `{"notificationType": "Complaint",
+				"complaint": {"complainedRecipients": [{"emailAddress": "a@example.com"}]}}`,
+			want: []listmonk.Bounce{{Email: "a@example.com", Source: "ses", Type: listmonk.BounceComplaint}},
+		},
+		{
+			name:  "delivery",
+			event: `{"notificationType": "Delivery", "delivery": {"recipients": ["a@example.com"]}}`,
+		},
+	} {
+		var ev sesEvent
+		if err := json.Unmarshal([]byte(c.event), &ev); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got, err := sesBounces(ev)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %d bounces, want %d", c.name, len(got), len(c.want))
+		}
+		for i := range got {
+			got[i].Meta = nil
+			if !reflect.DeepEqual(got[i], c.want[i]) {
+				t.Errorf("%s: got %+v, want %+v", c.name, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestHandleSESEvent(t *testing.T) {
+	var bounces []string
+	lm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bounces = append(bounces, string(b))
+		w.Write([]byte(`{"data": true}`))
+	}))
+	defer lm.Close()
+
+	c, err := listmonk.New(listmonk.Opt{URL: lm.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		logger:    onelog.New(os.Stderr, 0),
+		listmonk:  c,
+		sesEvents: &sesEvents{cfg: eventsCfg{SkipVerify: true, TopicARNs: []string{"arn:ses"}}},
+	}
+
+	event, _ := json.Marshal(map[string]string{
+		"Type":     "Notification",
+		"TopicArn": "arn:ses",
+		"Message":  `{"notificationType": "Bounce", "bounce": {"bounceType": "Permanent", "bouncedRecipients": [{"emailAddress": "a@example.com"}]}}`,
+	})
+
+	w := httptest.NewRecorder()
+	wrap(app, handleSESEvent)(w, httptest.NewRequest(http.MethodPost, "/events/ses", strings.NewReader(string(event))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if len(bounces) != 1 || !strings.Contains(bounces[0], `"type":"hard"`) {
+		t.Fatalf("unexpected bounces: %v", bounces)
+	}
+
+	// Messages from other topics are rejected.
+	event = []byte(strings.Replace(string(event), "arn:ses", "arn:other", 1))
+	w = httptest.NewRecorder()
+	wrap(app, handleSESEvent)(w, httptest.NewRequest(http.MethodPost, "/events/ses", strings.NewReader(string(event))))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/twilio/twilio-go/client"
+)
+
+const defaultMaxDeliveryStates = 10000
+
+// twilioEventsCfg is the config of the Twilio status callback endpoint.
+type twilioEventsCfg struct {
+	Enabled bool `koanf:"enabled"`
+	// URL is the public URL of /events/twilio, as set in the messengers'
+	// status_callback. Twilio signs requests with it.
+	URL string `koanf:"url"`
+	// AuthToken is the auth token callbacks are validated with if they are for
+	// an account none of the loaded twilio messengers sends from.
+	AuthToken string `koanf:"auth_token"`
+	// MaxStates is the number of recent messages whose states are kept.
+	MaxStates int `koanf:"max_states"`
+}
+
+// twilioEvents is the endpoint receiving Twilio status callbacks.
+type twilioEvents struct {
+	cfg twilioEventsCfg
+	// validators validate the callbacks of each account, by account SID.
+	validators map[string]client.RequestValidator
+	states     *deliveryStates
+}
+
+// twilioAccount is the part of a twilio messenger's config identifying the
+// account it sends from.
+type twilioAccount struct {
+	AccountID string `json:"account_id"`
+	AuthToken string `json:"auth_token"`
+}
+
+// validator returns the validator of the callbacks of the account sid, which
+// falls back to the configured auth token.
+func (e *twilioEvents) validator(sid string) (client.RequestValidator, bool) {
+	if v, ok := e.validators[sid]; ok {
+		return v, true
+	}
+	if e.cfg.AuthToken == "" {
+		return client.RequestValidator{}, false
+	}
+	return client.NewRequestValidator(e.cfg.AuthToken), true
+}
+
+// twilioHardBounces are Twilio delivery error codes reported as hard bounces.
+// Other failures are soft bounces.
+// See https://www.twilio.com/docs/api/errors
+var twilioHardBounces = map[string]bool{
+	"21211": true, // Invalid 'To' phone number.
+	"21610": true, // Recipient has unsubscribed (replied STOP).
+	"21614": true, // 'To' number is not a valid mobile number.
+	"30004": true, // Message blocked by the recipient.
+	"30005": true, // Unknown destination handset.
+	"30006": true, // Landline or unreachable carrier.
+}
+
+// handleTwilioEvent receives the status callbacks of Twilio messages, records
+// their states and reports failed and undelivered messages to listmonk as
+// bounces.
+func handleTwilioEvent(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		ev  = app.twilioEvents
+	)
+
+	if err := r.ParseForm(); err != nil {
+		sendErrorResponse(w, "invalid request", http.StatusBadRequest, nil)
+		return
+	}
+
+	v, ok := ev.validator(r.PostForm.Get("AccountSid"))
+	if !ok || !validTwilioSignature(r, ev.cfg.URL, v) {
+		sendErrorResponse(w, "invalid signature", http.StatusForbidden, nil)
+		return
+	}
+
+	var (
+		sid    = r.PostForm.Get("MessageSid")
+		status = r.PostForm.Get("MessageStatus")
+		code   = r.PostForm.Get("ErrorCode")
+	)
+	if sid == "" || status == "" {
+		sendErrorResponse(w, "invalid status callback", http.StatusBadRequest, nil)
+		return
+	}
+	metricEvents.WithLabelValues("twilio", status).Inc()
+
+	// Callbacks can arrive out of order and be retried. Only report a failure
+	// the first time the message reaches it.
+	if !ev.states.update(sid, status, code) || (status != "failed" && status != "undelivered") {
+		sendResponse(w, true)
+		return
+	}
+
+	subUUID := r.URL.Query().Get("subscriber_uuid")
+	if subUUID == "" {
+		app.logger.WarnWith("no subscriber in twilio status callback").String("sid", sid).Write()
+		sendResponse(w, true)
+		return
+	}
+
+	typ := listmonk.BounceSoft
+	if twilioHardBounces[code] {
+		typ = listmonk.BounceHard
+	}
+	meta, _ := json.Marshal(map[string]string{
+		"sid":        sid,
+		"status":     status,
+		"error_code": code,
+		"to":         r.PostForm.Get("To"),
+	})
+
+	b := listmonk.Bounce{
+		SubscriberUUID: subUUID,
+		CampaignUUID:   r.URL.Query().Get("campaign_uuid"),
+		Source:         "twilio",
+		Type:           typ,
+		Meta:           meta,
+	}
+	if err := app.listmonk.RecordBounce(r.Context(), b); err != nil {
+		app.logger.ErrorWith("error recording bounce").String("sid", sid).Err("err", err).Write()
+
+		// Forget the state so that Twilio's retry is reported again, unless
+		// listmonk rejected the bounce.
+		if listmonk.IsTemporary(err) {
+			ev.states.forget(sid)
+			sendErrorResponse(w, "error recording bounce", http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	sendResponse(w, true)
+}
+
+// validTwilioSignature reports whether r carries a valid X-Twilio-Signature
+// for u, the public URL of the endpoint. Twilio signs the URL with the query
+// it was called with, which includes any query of u, and the POST params. r's
+// form must have been parsed.
+func validTwilioSignature(r *http.Request, u string, v client.RequestValidator) bool {
+	params := make(map[string]string, len(r.PostForm))
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+	u, _, _ = strings.Cut(u, "?")
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	return v.Validate(u, params, r.Header.Get("X-Twilio-Signature"))
+}
+
+// handleGetTwilioState returns the recorded delivery state of a message.
+func handleGetTwilioState(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	s, ok := app.twilioEvents.states.get(chi.URLParam(r, "sid"))
+	if !ok {
+		sendErrorResponse(w, "message not found", http.StatusNotFound, nil)
+		return
+	}
+
+	sendResponse(w, s)
+}
+
+// deliveryState is the latest known delivery state of a message.
+type deliveryState struct {
+	Status    string    `json:"status"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// deliveryStates records the delivery states of the most recent messages by
+// their provider message ID, evicting the oldest past max.
+type deliveryStates struct {
+	max int
+
+	mu     sync.Mutex
+	states map[string]deliveryState
+	order  []string
+}
+
+// twilioStatusRanks orders Twilio message statuses by progress. Statuses of
+// rank 3 are final.
+var twilioStatusRanks = map[string]int{
+	"accepted":    0,
+	"scheduled":   0,
+	"queued":      0,
+	"sending":     1,
+	"sent":        2,
+	"delivered":   3,
+	"undelivered": 3,
+	"failed":      3,
+	"read":        3,
+	"canceled":    3,
+}
+
+func newDeliveryStates(max int) *deliveryStates {
+	if max < 1 {
+		max = defaultMaxDeliveryStates
+	}
+	return &deliveryStates{max: max, states: make(map[string]deliveryState)}
+}
+
+// update records the status of a message. It returns false, ignoring the
+// status, if the message has already reached the same or a later status.
+func (d *deliveryStates) update(id, status, code string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cur, ok := d.states[id]
+	if ok {
+		if cur.Status == status || twilioStatusRanks[cur.Status] == 3 ||
+			twilioStatusRanks[status] < twilioStatusRanks[cur.Status] {
+			return false
+		}
+	} else {
+		if len(d.order) >= d.max {
+			delete(d.states, d.order[0])
+			d.order = d.order[1:]
+		}
+		d.order = append(d.order, id)
+	}
+
+	d.states[id] = deliveryState{Status: status, ErrorCode: code, UpdatedAt: time.Now()}
+	return true
+}
+
+func (d *deliveryStates) get(id string) (deliveryState, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.states[id]
+	return s, ok
+}
+
+// forget removes the state of a message.
+func (d *deliveryStates) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.states[id]; !ok {
+		return
+	}
+	delete(d.states, id)
+	for i, v := range d.order {
+		if v == id {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
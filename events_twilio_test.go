@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/twilio/twilio-go/client"
+)
+
+// twilioSign returns the X-Twilio-Signature of a callback.
+func twilioSign(token, u string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := u
+	for _, k := range keys {
+		s += k + form.Get(k)
+	}
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandleTwilioEvent(t *testing.T) {
+	var bounces []string
+	lm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bounces = append(bounces, string(b))
+		w.Write([]byte(`{"data": true}`))
+	}))
+	defer lm.Close()
+
+	c, err := listmonk.New(listmonk.Opt{URL: lm.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pubURL = "https://messenger.example.com/events/twilio"
+	app := &App{
+		logger:   onelog.New(os.Stderr, 0),
+		listmonk: c,
+		twilioEvents: &twilioEvents{
+			cfg:        twilioEventsCfg{URL: pubURL},
+			validators: map[string]client.RequestValidator{"AC1": client.NewRequestValidator("token")},
+			states:     newDeliveryStates(0),
+		},
+	}
+
+	send := func(status, code, sig string) int {
+		form := url.Values{"AccountSid": {"AC1"}, "MessageSid": {"SM1"}, "MessageStatus": {status}, "ErrorCode": {code}, "To": {"+15005550006"}}
+		query := "?subscriber_uuid=sub&campaign_uuid=camp"
+		if sig == "" {
+			sig = twilioSign("token", pubURL+query, form)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/events/twilio"+query, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", sig)
+		w := httptest.NewRecorder()
+		wrap(app, handleTwilioEvent)(w, req)
+		return w.Code
+	}
+
+	if code := send("undelivered", "30005", "forged"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for invalid signature, got %d", code)
+	}
+	for _, s := range []string{"sent", "undelivered", "sending", "undelivered"} {
+		if code := send(s, "30005", ""); code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", s, code)
+		}
+	}
+
+	if len(bounces) != 1 {
+		t.Fatalf("expected 1 bounce, got %v", bounces)
+	}
+	for _, want := range []string{`"subscriber_uuid":"sub"`, `"campaign_uuid":"camp"`, `"type":"hard"`, `"source":"twilio"`} {
+		if !strings.Contains(bounces[0], want) {
+			t.Errorf("bounce missing %s: %s", want, bounces[0])
+		}
+	}
+	if s, _ := app.twilioEvents.states.get("SM1"); s.Status != "undelivered" {
+		t.Errorf("unexpected state: %+v", s)
+	}
+}
+
+func TestDeliveryStatesEviction(t *testing.T) {
+	d := newDeliveryStates(2)
+	d.update("a", "sent", "")
+	d.update("b", "sent", "")
+	d.update("c", "sent", "")
+
+	if _, ok := d.get("a"); ok {
+		t.Error("expected the oldest state to be evicted")
+	}
+	if _, ok := d.get("c"); !ok {
+		t.Error("expected the newest state to be kept")
+	}
+}
+
+func TestTwilioEventSignature(t *testing.T) {
+	// The configured URL has a query of its own, which Twilio calls back with
+	// the message's.
+	const pubURL = "https://messenger.example.com/events/twilio?key=k"
+	ev := &twilioEvents{
+		cfg: twilioEventsCfg{URL: pubURL},
+		validators: map[string]client.RequestValidator{
+			"AC1": client.NewRequestValidator("token1"),
+			"AC2": client.NewRequestValidator("token2"),
+		},
+		states: newDeliveryStates(0),
+	}
+	app := &App{logger: onelog.New(os.Stderr, 0), twilioEvents: ev}
+
+	send := func(account, token string) int {
+		form := url.Values{"AccountSid": {account}, "MessageSid": {"SM" + account + token}, "MessageStatus": {"sent"}}
+		query := "?key=k&subscriber_uuid=sub&campaign_uuid=camp"
+
+		req := httptest.NewRequest(http.MethodPost, "/events/twilio"+query, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", twilioSign(token, "https://messenger.example.com/events/twilio"+query, form))
+		w := httptest.NewRecorder()
+		wrap(app, handleTwilioEvent)(w, req)
+		return w.Code
+	}
+
+	for _, c := range []struct {
+		account, token string
+		want           int
+	}{
+		{"AC1", "token1", http.StatusOK},
+		{"AC2", "token2", http.StatusOK},
+		{"AC2", "token1", http.StatusForbidden},
+		{"AC3", "token1", http.StatusForbidden},
+	} {
+		if code := send(c.account, c.token); code != c.want {
+			t.Errorf("%s signed with %s: expected %d, got %d", c.account, c.token, c.want, code)
+		}
+	}
+
+	// Other accounts are validated with the configured auth token.
+	ev.cfg.AuthToken = "token3"
+	if code := send("AC3", "token3"); code != http.StatusOK {
+		t.Errorf("expected 200 with the configured auth token, got %d", code)
+	}
+}
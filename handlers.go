@@ -3,16 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/textproto"
+	"strconv"
+	"sync"
 
 	"github.com/go-chi/chi"
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
 	"github.com/joeirimpan/listmonk-messenger/messenger"
 	"github.com/knadh/listmonk/models"
 )
 
+const (
+	statusSent   = "sent"
+	statusFailed = "failed"
+	statusQueued = "queued"
+
+	// codeNoRoute is the error code of messages no messenger is routed to.
+	codeNoRoute = "no_route"
+)
+
 type postback struct {
 	Subject     string       `json:"subject"`
 	FromEmail   string       `json:"from_email"`
@@ -44,25 +58,84 @@ type attachment struct {
 	Content []byte               `json:"content"`
 }
 
+// pushResult is the outcome of sending a message to a single recipient.
+type pushResult struct {
+	UUID   string `json:"uuid"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Code is the machine readable class of Error, if known.
+	Code string `json:"code,omitempty"`
+	// Messenger is the messenger a routed message was sent with.
+	Messenger string `json:"messenger,omitempty"`
+	// Channels are the outcomes of each channel of a fan-out.
+	Channels []channelResult `json:"channels,omitempty"`
+}
+
+// channelResult is the outcome of sending a message through one channel of a
+// fan-out.
+type channelResult struct {
+	Messenger string `json:"messenger"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
 type httpResp struct {
 	Status  string      `json:"status"`
 	Message string      `json:"message,omitempty"`
+	Code    string      `json:"code,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// handlePostback picks the messager based on url params and pushes message using it.
+// errorStatuses maps push error classes to HTTP status codes.
+var errorStatuses = map[*messenger.ErrorClass]int{
+	messenger.ErrInvalidRecipient: http.StatusUnprocessableEntity,
+	messenger.ErrContentRejected:  http.StatusUnprocessableEntity,
+	messenger.ErrAuth:             http.StatusBadGateway,
+	messenger.ErrThrottled:        http.StatusTooManyRequests,
+	messenger.ErrProviderOutage:   http.StatusServiceUnavailable,
+}
+
+// handlePostback picks the messager based on url params and pushes a message
+// to every recipient in the postback using it.
 func handlePostback(w http.ResponseWriter, r *http.Request) {
 	var (
 		app      = r.Context().Value("app").(*App)
 		provider = chi.URLParam(r, "provider")
 	)
 
+	data, ok := readPostback(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the provider.
+	if _, ok := app.messengers[provider]; !ok {
+		sendErrorResponse(w, "unknown provider", http.StatusBadRequest, nil)
+		return
+	}
+
+	msgs := makeMessages(data)
+	names := make([]string, len(msgs))
+	for n := range names {
+		names[n] = provider
+	}
+
+	dispatch(w, r, app, names, msgs, false)
+}
+
+// readPostback decodes the postback in the request body. On failure, it
+// writes the error response and returns false.
+func readPostback(w http.ResponseWriter, r *http.Request) (*postback, bool) {
+	app := r.Context().Value("app").(*App)
+
 	// Decode body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		app.logger.ErrorWith("error reading request body").Err("err", err).Write()
 		sendErrorResponse(w, "invalid body", http.StatusBadRequest, nil)
-		return
+		return nil, false
 	}
 	defer r.Body.Close()
 
@@ -70,38 +143,275 @@ func handlePostback(w http.ResponseWriter, r *http.Request) {
 	if err := json.Unmarshal(body, &data); err != nil {
 		app.logger.ErrorWith("error unmarshalling request body").Err("err", err).Write()
 		sendErrorResponse(w, "invalid body", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	if len(data.Recipients) == 0 {
+		sendErrorResponse(w, "invalid recipients", http.StatusBadRequest, nil)
+		return nil, false
+	}
+
+	return data, true
+}
+
+// dispatch pushes every message using the messenger of the same index in
+// names, or queues them, and writes the results. Messages without a
+// messenger fail as unroutable. If routed is set, the results include the
+// messenger of each message.
+func dispatch(w http.ResponseWriter, r *http.Request, app *App, names []string, msgs []messenger.Message, routed bool) {
+	counts := make(map[string]int)
+	for _, name := range names {
+		if name != "" {
+			counts[name]++
+		}
+	}
+	for name, n := range counts {
+		metricReceived.WithLabelValues(name).Add(float64(n))
+	}
+
+	// Turn away postbacks over the rate limit so that listmonk backs off.
+	// Messages past the burst of an admitted postback, and queued messages,
+	// are held until the limit allows them instead.
+	admitted := make([]bool, len(msgs))
+	if app.queue == nil {
+		var cancels []func()
+		for name, n := range counts {
+			lim := app.opts[name].limiter
+			if lim == nil || !lim.reject {
+				continue
+			}
+
+			k, cancel, after := lim.admit(n)
+			if cancel == nil {
+				for _, c := range cancels {
+					c()
+				}
+				metricRateLimited.WithLabelValues(name).Add(float64(n))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+				sendErrorResponse(w, "rate limit exceeded", http.StatusTooManyRequests, nil)
+				return
+			}
+			cancels = append(cancels, cancel)
+
+			for i := range msgs {
+				if k > 0 && names[i] == name {
+					admitted[i] = true
+					k--
+				}
+			}
+		}
+	}
+
+	results := make([]pushResult, len(msgs))
+	errs := make([]*messenger.ErrorClass, len(msgs))
+	for n, m := range msgs {
+		results[n] = pushResult{UUID: m.Subscriber.UUID, Email: m.Subscriber.Email}
+		if routed {
+			results[n].Messenger = names[n]
+		}
+		if names[n] == "" {
+			results[n].Status = statusFailed
+			results[n].Error = "no messenger matches the recipient"
+			results[n].Code = codeNoRoute
+		}
+	}
+
+	// With the queue enabled, persist the messages and acknowledge right away.
+	// They are pushed in the background by the queue workers.
+	// The postback is queued as a whole so that a failure doesn't leave part
+	// of it to be sent again when listmonk retries.
+	if app.queue != nil {
+		batch := make(map[string][]messenger.Message, len(counts))
+		for n, m := range msgs {
+			if names[n] != "" {
+				batch[names[n]] = append(batch[names[n]], m)
+			}
+		}
+		if err := app.queue.PushBatch(batch); err != nil {
+			app.logger.ErrorWith("error queueing messages").Err("err", err).Write()
+			sendErrorResponse(w, "error queueing message", http.StatusInternalServerError, nil)
+			return
+		}
+
+		for n := range msgs {
+			if names[n] != "" {
+				results[n].Status = statusQueued
+			}
+		}
+		sendStatusResponse(w, http.StatusAccepted, results)
 		return
 	}
 
-	// Get the provider.
-	p, ok := app.messengers[provider]
-	if !ok {
-		sendErrorResponse(w, "unknown provider", http.StatusBadRequest, nil)
+	// Retries and rate limit waits past the write timeout would have the
+	// connection dropped after the messages were sent, and listmonk send them
+	// again.
+	ctx := r.Context()
+	if app.pushDeadline > 0 {
+		c, cancel := context.WithTimeout(ctx, app.pushDeadline)
+		defer cancel()
+		ctx = c
+	}
+
+	// Push one message per recipient with a bounded number of workers.
+	var (
+		jobs = make(chan int)
+		wg   sync.WaitGroup
+	)
+	workers := app.concurrency
+	if workers > len(msgs) {
+		workers = len(msgs)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				res, class := push(ctx, app, names[n], app.messengers[names[n]], msgs[n], admitted[n])
+				res.Messenger = results[n].Messenger
+				results[n], errs[n] = res, class
+			}
+		}()
+	}
+	for n := range msgs {
+		if names[n] != "" {
+			jobs <- n
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Fail the request only if none of the recipients could be sent to.
+	var (
+		sent  = 0
+		class = errs[0]
+	)
+	for n, res := range results {
+		if res.Status == statusSent {
+			sent++
+		}
+		if class != errs[n] {
+			class = nil
+		}
+	}
+	if sent == 0 {
+		if len(counts) == 0 {
+			sendErrorResponse(w, "no messenger matches the recipients", http.StatusUnprocessableEntity, results)
+			return
+		}
+		sendPushErrorResponse(w, class, results)
 		return
 	}
 
-	if len(data.Recipients) > 1 {
-		sendErrorResponse(w, "invalid recipients", http.StatusBadRequest, nil)
+	sendResponse(w, results)
+}
+
+// sendPushErrorResponse sends an error envelope for a failed push with the
+// HTTP status and code of the error class. Unknown errors are sent as 500s.
+func sendPushErrorResponse(w http.ResponseWriter, class *messenger.ErrorClass, data interface{}) {
+	code, ok := errorStatuses[class]
+	if !ok {
+		sendErrorResponse(w, "error sending message", http.StatusInternalServerError, data)
 		return
 	}
 
-	rec := data.Recipients[0]
-	message := messenger.Message{
-		From:        data.FromEmail,
-		Subject:     data.Subject,
-		ContentType: data.ContentType,
-		Body:        []byte(data.Body),
-		Subscriber: models.Subscriber{
-			UUID:    rec.UUID,
-			Email:   rec.Email,
-			Name:    rec.Name,
-			Status:  rec.Status,
-			Attribs: rec.Attribs,
-		},
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+
+	out, _ := json.Marshal(httpResp{Status: "error",
+		Message: "error sending message: " + class.Error(),
+		Code:    class.Code,
+		Data:    data})
+	w.Write(out)
+}
+
+// push sends a single message using the given messenger, retrying transient
+// failures as per the messenger's retry policy, and records the outcome along
+// with the error class, if any. Messages that still fail are written to the
+// dead-letter store if enabled. The first attempt of an admitted message has
+// already been taken off the messenger's rate limit.
+func push(ctx context.Context, app *App, provider string, p messenger.Messenger, msg messenger.Message, admitted bool) (pushResult, *messenger.ErrorClass) {
+	res := pushResult{
+		UUID:   msg.Subscriber.UUID,
+		Email:  msg.Subscriber.Email,
+		Status: statusSent,
+	}
+
+	app.logger.DebugWith("sending message").String("provider", provider).String("message", fmt.Sprintf("%#+v", msg)).Write()
+
+	// Collect the outcome of each channel, should p be a fan-out.
+	ctx, channels := messenger.WithChannelResults(ctx)
+
+	opts := app.opts[provider]
+	attempts, err := opts.retry.do(ctx, func() error {
+		if opts.limiter != nil && !admitted {
+			waited, err := opts.limiter.wait(ctx)
+			if waited {
+				metricRateLimited.WithLabelValues(provider).Inc()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		admitted = false
+
+		ctx := ctx
+		if opts.timeout > 0 {
+			c, cancel := context.WithTimeout(ctx, opts.timeout)
+			defer cancel()
+			ctx = c
+		}
+		return messenger.PushContext(ctx, p, msg)
+	})
+	recordPush(provider, attempts, err)
+	res.Channels = makeChannelResults(channels())
+	if err != nil {
+		app.logger.ErrorWith("error sending message").String("provider", provider).String("uuid", msg.Subscriber.UUID).Int("attempts", attempts).Err("err", err).Write()
+		res.Status = statusFailed
+		res.Error = err.Error()
+
+		class := messenger.Class(err)
+		if class != nil {
+			res.Code = class.Code
+		}
+
+		// Interrupted pushes are not failures of the message itself.
+		if app.deadLetter != nil && ctx.Err() == nil {
+			if err := app.deadLetter.Bury(provider, msg, attempts, err); err != nil {
+				app.logger.ErrorWith("error writing to dead-letter store").String("provider", provider).Err("err", err).Write()
+			}
+		}
+		return res, class
 	}
 
+	return res, nil
+}
+
+// makeChannelResults converts fan-out channel results to their response.
+func makeChannelResults(in []messenger.ChannelResult) []channelResult {
+	if len(in) == 0 {
+		return nil
+	}
+
+	out := make([]channelResult, 0, len(in))
+	for _, r := range in {
+		c := channelResult{Messenger: r.Messenger, Status: statusSent}
+		if r.Err != nil {
+			c.Status = statusFailed
+			c.Error = r.Err.Error()
+			if class := messenger.Class(r.Err); class != nil {
+				c.Code = class.Code
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// makeMessages builds one messenger.Message for every recipient in the postback.
+func makeMessages(data *postback) []messenger.Message {
+	var camp *models.Campaign
 	if data.Campaign != nil {
-		message.Campaign = &models.Campaign{
+		camp = &models.Campaign{
 			FromEmail: data.Campaign.FromEmail,
 			UUID:      data.Campaign.UUID,
 			Name:      data.Campaign.Name,
@@ -109,8 +419,9 @@ func handlePostback(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var files []messenger.Attachment
 	if len(data.Attachments) > 0 {
-		files := make([]messenger.Attachment, 0, len(data.Attachments))
+		files = make([]messenger.Attachment, 0, len(data.Attachments))
 		for _, f := range data.Attachments {
 			a := messenger.Attachment{
 				Name:    f.Name,
@@ -120,21 +431,126 @@ func handlePostback(w http.ResponseWriter, r *http.Request) {
 			copy(a.Content, f.Content)
 			files = append(files, a)
 		}
+	}
 
-		message.Attachments = files
+	msgs := make([]messenger.Message, 0, len(data.Recipients))
+	for _, rec := range data.Recipients {
+		msgs = append(msgs, messenger.Message{
+			From:        data.FromEmail,
+			Subject:     data.Subject,
+			ContentType: data.ContentType,
+			Body:        []byte(data.Body),
+			Attachments: files,
+			Subscriber: models.Subscriber{
+				UUID:    rec.UUID,
+				Email:   rec.Email,
+				Name:    rec.Name,
+				Status:  rec.Status,
+				Attribs: rec.Attribs,
+			},
+			Campaign: camp,
+		})
 	}
 
-	app.logger.DebugWith("sending message").String("provider", provider).String("message", fmt.Sprintf("%#+v", message)).Write()
+	return msgs
+}
+
+// handleGetDeadLetter lists the messages in the dead-letter store, optionally
+// filtered by the messenger query param.
+func handleGetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
 
-	// Send message.
-	if err := p.Push(message); err != nil {
-		app.logger.ErrorWith("error sending message").Err("err", err).Write()
-		sendErrorResponse(w, "error sending message", http.StatusInternalServerError, nil)
+	out, err := app.deadLetter.Dead(r.URL.Query().Get("messenger"))
+	if err != nil {
+		app.logger.ErrorWith("error reading dead-letter store").Err("err", err).Write()
+		sendErrorResponse(w, "error reading dead-letter store", http.StatusInternalServerError, nil)
 		return
 	}
 
-	sendResponse(w, "OK")
-	return
+	sendResponse(w, out)
+}
+
+// handleReplayDeadLetter sends a dead-lettered message again and removes it
+// from the store. With the queue enabled, the message is re-queued instead.
+func handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	j, ok := getDeadJob(w, r)
+	if !ok {
+		return
+	}
+
+	p, ok := app.messengers[j.Messenger]
+	if !ok {
+		sendErrorResponse(w, "unknown provider", http.StatusBadRequest, nil)
+		return
+	}
+	if j.Raw != "" {
+		sendErrorResponse(w, "message couldn't be decoded from the queue", http.StatusBadRequest, nil)
+		return
+	}
+
+	var res pushResult
+	if app.queue != nil {
+		if err := app.queue.Push(j.Messenger, j.Message); err != nil {
+			app.logger.ErrorWith("error queueing message").String("provider", j.Messenger).Err("err", err).Write()
+			sendErrorResponse(w, "error queueing message", http.StatusInternalServerError, nil)
+			return
+		}
+		res = pushResult{UUID: j.Message.Subscriber.UUID, Email: j.Message.Subscriber.Email, Status: statusQueued}
+	} else {
+		// A failed replay is dead-lettered again with a new ID.
+		res, _ = push(r.Context(), app, j.Messenger, p, j.Message, false)
+	}
+
+	if err := app.deadLetter.DeleteDead(j.ID); err != nil {
+		app.logger.ErrorWith("error deleting from dead-letter store").Err("err", err).Write()
+	}
+
+	sendResponse(w, res)
+}
+
+// handleDeleteDeadLetter removes a message from the dead-letter store.
+func handleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	j, ok := getDeadJob(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.deadLetter.DeleteDead(j.ID); err != nil {
+		app.logger.ErrorWith("error deleting from dead-letter store").Err("err", err).Write()
+		sendErrorResponse(w, "error deleting message", http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(w, true)
+}
+
+// getDeadJob fetches the dead-lettered message in the {id} url param. On
+// failure, it writes the error response and returns false.
+func getDeadJob(w http.ResponseWriter, r *http.Request) (queue.DeadJob, bool) {
+	app := r.Context().Value("app").(*App)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "invalid id", http.StatusBadRequest, nil)
+		return queue.DeadJob{}, false
+	}
+
+	j, err := app.deadLetter.GetDead(id)
+	if err != nil {
+		if errors.Is(err, queue.ErrNotFound) {
+			sendErrorResponse(w, "message not found", http.StatusNotFound, nil)
+		} else {
+			app.logger.ErrorWith("error reading dead-letter store").Err("err", err).Write()
+			sendErrorResponse(w, "error reading dead-letter store", http.StatusInternalServerError, nil)
+		}
+		return queue.DeadJob{}, false
+	}
+
+	return j, true
 }
 
 // handleHealthCheck responds with a 200 for monitoring/liveness probes.
@@ -142,9 +558,13 @@ func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, "OK")
 }
 
-// wrap is a middleware that wraps HTTP handlers and injects the "app" context.
+// wrap is a middleware that wraps HTTP handlers, injects the "app" context and
+// tracks the request until the handler returns.
 func wrap(app *App, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.requests.Add(1)
+		defer app.requests.Done()
+
 		ctx := context.WithValue(r.Context(), "app", app)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -152,6 +572,12 @@ func wrap(app *App, next http.HandlerFunc) http.HandlerFunc {
 
 // sendResponse sends a JSON envelope to the HTTP response.
 func sendResponse(w http.ResponseWriter, data interface{}) {
+	sendStatusResponse(w, http.StatusOK, data)
+}
+
+// sendStatusResponse sends a JSON envelope to the HTTP response with the given
+// status code.
+func sendStatusResponse(w http.ResponseWriter, code int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	out, err := json.Marshal(httpResp{Status: "success", Data: data})
 	if err != nil {
@@ -159,6 +585,7 @@ func sendResponse(w http.ResponseWriter, data interface{}) {
 		return
 	}
 
+	w.WriteHeader(code)
 	w.Write(out)
 }
 
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/go-chi/chi"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+// postbackResp is the response envelope of a postback.
+type postbackResp struct {
+	Status  string       `json:"status"`
+	Message string       `json:"message"`
+	Code    string       `json:"code"`
+	Data    []pushResult `json:"data"`
+}
+
+// sendPostback posts body to the webhook of the messenger m and decodes the
+// response.
+func sendPostback(t *testing.T, m *stubMessenger, body string) (int, postbackResp) {
+	t.Helper()
+
+	app := &App{
+		logger:      onelog.New(os.Stderr, 0),
+		concurrency: 1,
+		messengers:  map[string]messenger.Messenger{m.name: m},
+		opts:        map[string]msgrOpts{},
+	}
+	r := chi.NewRouter()
+	r.Post("/webhook/{provider}", wrap(app, handlePostback))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/"+m.name, strings.NewReader(body)))
+
+	var resp postbackResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body, err)
+	}
+	return w.Code, resp
+}
+
+const batchPostback = `{"body": "hi", "recipients": [
+	{"uuid": "a", "email": "a@example.com"},
+	{"uuid": "b", "email": "b@example.com"},
+	{"uuid": "c", "email": "c@example.com"}
+]}`
+
+func TestPostbackInvalid(t *testing.T) {
+	for _, body := range []string{`{"body": "hi", "recipients": []}`, `{"body": "hi"}`, `{`} {
+		m := &stubMessenger{name: "sms"}
+		if code, _ := sendPostback(t, m, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+		if m.pushed() != 0 {
+			t.Errorf("%s: expected no pushes", body)
+		}
+	}
+
+	m := &stubMessenger{name: "sms"}
+	app := &App{logger: onelog.New(os.Stderr, 0), messengers: map[string]messenger.Messenger{"sms": m}}
+	r := chi.NewRouter()
+	r.Post("/webhook/{provider}", wrap(app, handlePostback))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/ses", strings.NewReader(batchPostback)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown provider, got %d", w.Code)
+	}
+}
+
+func TestPostbackBatch(t *testing.T) {
+	m := &stubMessenger{name: "sms"}
+	code, resp := sendPostback(t, m, batchPostback)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", code, resp)
+	}
+	if m.pushed() != 3 {
+		t.Fatalf("expected 3 pushes, got %d", m.pushed())
+	}
+
+	if len(resp.Data) != 3 {
+		t.Fatalf("expected 3 results, got %+v", resp.Data)
+	}
+	for n, uuid := range []string{"a", "b", "c"} {
+		res := resp.Data[n]
+		if res.UUID != uuid || res.Email != uuid+"@example.com" || res.Status != statusSent {
+			t.Errorf("unexpected result %d: %+v", n, res)
+		}
+	}
+}
+
+func TestPostbackPartialFailure(t *testing.T) {
+	// With a single worker, recipients are pushed in order.
+	m := &stubMessenger{name: "sms", errs: []error{messenger.NewError(messenger.ErrInvalidRecipient, errors.New("no phone"))}}
+	code, resp := sendPostback(t, m, batchPostback)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", code, resp)
+	}
+
+	if res := resp.Data[0]; res.Status != statusFailed || res.Code != messenger.ErrInvalidRecipient.Code || res.Error == "" {
+		t.Errorf("unexpected failed result: %+v", res)
+	}
+	for _, res := range resp.Data[1:] {
+		if res.Status != statusSent {
+			t.Errorf("unexpected result: %+v", res)
+		}
+	}
+}
+
+func TestPostbackAllFailed(t *testing.T) {
+	throttled := messenger.NewError(messenger.ErrThrottled, errors.New("slow down"))
+	m := &stubMessenger{name: "sms", errs: []error{throttled, throttled, throttled}}
+	code, resp := sendPostback(t, m, batchPostback)
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %+v", code, resp)
+	}
+	if resp.Status != "error" || resp.Code != messenger.ErrThrottled.Code || len(resp.Data) != 3 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// Failures of different classes are reported as a 500.
+	m = &stubMessenger{name: "sms", errs: []error{
+		throttled,
+		messenger.NewError(messenger.ErrInvalidRecipient, errors.New("no phone")),
+		errors.New("unknown"),
+	}}
+	if code, resp := sendPostback(t, m, batchPostback); code != http.StatusInternalServerError || len(resp.Data) != 3 {
+		t.Errorf("expected 500 with the results, got %d: %+v", code, resp)
+	}
+}
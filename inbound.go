@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/joeirimpan/listmonk-messenger/internal/sns"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/joeirimpan/listmonk-messenger/messenger/phone"
+	"github.com/twilio/twilio-go/client"
+)
+
+const (
+	inboundOptOut = "opt_out"
+	inboundOptIn  = "opt_in"
+
+	// inboundBlocklist blocklists opted out subscribers instead of
+	// unsubscribing them from lists.
+	inboundBlocklist   = "blocklist"
+	inboundUnsubscribe = "unsubscribe"
+)
+
+// Default keywords, as recognised by carriers and providers in English,
+// French, Spanish, German and the Nordic languages.
+var (
+	defaultOptOutKeywords = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT", "OPTOUT",
+		"ARRET", "ARRÊT", "ALTO", "PARAR", "BAJA", "STOPP", "ABMELDEN"}
+	defaultOptInKeywords = []string{"START", "UNSTOP", "OPTIN", "ALTA", "ANMELDEN"}
+)
+
+// optOutListsAttrib is the subscriber attribute recording the lists an SMS
+// opt-out unsubscribed the subscriber from, which opting back in resubscribes
+// it to.
+const optOutListsAttrib = "sms_opt_out_lists"
+
+// inboundCfg is the config of the inbound SMS endpoints.
+type inboundCfg struct {
+	OptOutKeywords []string `koanf:"opt_out_keywords"`
+	OptInKeywords  []string `koanf:"opt_in_keywords"`
+	// Action is what's done to subscribers that opt out, "unsubscribe" or
+	// "blocklist".
+	Action string `koanf:"action"`
+	// ListIDs are the lists subscribers are unsubscribed from and
+	// resubscribed to. Empty means the lists they are subscribed to when
+	// opting out, and those they were unsubscribed from when opting in.
+	ListIDs []int `koanf:"list_ids"`
+	// DefaultCountryCode is the country code of numbers in the "phone"
+	// attribute stored without one, as in the SMS messengers' config.
+	DefaultCountryCode string `koanf:"default_country_code"`
+
+	Twilio struct {
+		Enabled   bool   `koanf:"enabled"`
+		URL       string `koanf:"url"`
+		AuthToken string `koanf:"auth_token"`
+	} `koanf:"twilio"`
+	SNS eventsCfg `koanf:"sns"`
+}
+
+// inbound handles inbound SMS replies.
+type inbound struct {
+	cfg      inboundCfg
+	keywords map[string]string
+	// phones are the ways subscriber numbers are read, which senders are
+	// matched against.
+	phones []messenger.PhoneOpt
+
+	twilio   client.RequestValidator
+	verifier *sns.Verifier
+}
+
+// snsSMS is a two-way SMS received by a Pinpoint or End User Messaging number
+// and published to SNS.
+type snsSMS struct {
+	OriginationNumber      string `json:"originationNumber"`
+	DestinationPhoneNumber string `json:"destinationPhoneNumber"`
+	MessageBody            string `json:"messageBody"`
+	InboundMessageID       string `json:"inboundMessageId"`
+}
+
+// newInbound creates the inbound SMS handler. Senders are matched against the
+// "phone" attribute as well as the numbers read as in phones, usually those of
+// the loaded messengers.
+func newInbound(cfg inboundCfg, phones []messenger.PhoneOpt) (*inbound, error) {
+	switch cfg.Action {
+	case "":
+		cfg.Action = inboundUnsubscribe
+	case inboundUnsubscribe, inboundBlocklist:
+	default:
+		return nil, fmt.Errorf("invalid action: %s", cfg.Action)
+	}
+	cc, err := phone.CountryCode(cfg.DefaultCountryCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default_country_code")
+	}
+	cfg.DefaultCountryCode = cc
+
+	if len(cfg.OptOutKeywords) == 0 {
+		cfg.OptOutKeywords = defaultOptOutKeywords
+	}
+	if len(cfg.OptInKeywords) == 0 {
+		cfg.OptInKeywords = defaultOptInKeywords
+	}
+
+	in := &inbound{cfg: cfg, keywords: make(map[string]string)}
+
+	seen := make(map[string]bool)
+	for _, o := range append([]messenger.PhoneOpt{{DefaultCountryCode: cc}}, phones...) {
+		o.DefaultCountryCode, _ = phone.CountryCode(o.DefaultCountryCode)
+		if k := fmt.Sprint(o.Attribs(), o.DefaultCountryCode); !seen[k] {
+			seen[k] = true
+			in.phones = append(in.phones, o)
+		}
+	}
+	for _, k := range cfg.OptOutKeywords {
+		in.keywords[normalizeKeyword(k)] = inboundOptOut
+	}
+	for _, k := range cfg.OptInKeywords {
+		k = normalizeKeyword(k)
+		if in.keywords[k] == inboundOptOut {
+			return nil, fmt.Errorf("keyword %s is both an opt-out and an opt-in keyword", k)
+		}
+		in.keywords[k] = inboundOptIn
+	}
+
+	if cfg.Twilio.Enabled {
+		if cfg.Twilio.URL == "" || cfg.Twilio.AuthToken == "" {
+			return nil, fmt.Errorf("twilio requires url and auth_token")
+		}
+		in.twilio = client.NewRequestValidator(cfg.Twilio.AuthToken)
+	}
+	if cfg.SNS.Enabled {
+		v, err := sns.NewVerifier(sns.VerifierOpt{CertFile: cfg.SNS.CertFile, CertHost: cfg.SNS.CertHost})
+		if err != nil {
+			return nil, err
+		}
+		in.verifier = v
+	}
+
+	return in, nil
+}
+
+// keyword returns the action of the keyword a message consists of, if any.
+func (in *inbound) keyword(body string) string {
+	return in.keywords[normalizeKeyword(body)]
+}
+
+// normalizeKeyword upper cases s and strips surrounding spaces and
+// punctuation, so that eg. "Stop." matches STOP.
+func normalizeKeyword(s string) string {
+	return strings.ToUpper(strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}))
+}
+
+// handleTwilioInbound receives SMS replies to Twilio numbers.
+func handleTwilioInbound(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	if err := r.ParseForm(); err != nil {
+		sendErrorResponse(w, "invalid request", http.StatusBadRequest, nil)
+		return
+	}
+	if !validTwilioSignature(r, app.inbound.cfg.Twilio.URL, app.inbound.twilio) {
+		sendErrorResponse(w, "invalid signature", http.StatusForbidden, nil)
+		return
+	}
+
+	if err := handleInbound(r.Context(), app, "twilio", r.PostForm.Get("From"), r.PostForm.Get("Body")); err != nil {
+		sendErrorResponse(w, "error processing message", http.StatusInternalServerError, nil)
+		return
+	}
+
+	// An empty TwiML response, as Twilio sends the opt-out confirmation.
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Response></Response>`))
+}
+
+// handleSNSInbound receives two-way SMS replies to Pinpoint or End User
+// Messaging numbers via SNS.
+func handleSNSInbound(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	m, ok := readSNSMessage(w, r, app.inbound.cfg.SNS, app.inbound.verifier)
+	if !ok {
+		return
+	}
+	if m.Type != sns.TypeNotification {
+		sendResponse(w, true)
+		return
+	}
+
+	var sms snsSMS
+	if err := json.Unmarshal([]byte(m.Message), &sms); err != nil {
+		sendErrorResponse(w, "invalid SMS notification", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Fail so that SNS retries the notification.
+	if err := handleInbound(r.Context(), app, "sns", sms.OriginationNumber, sms.MessageBody); err != nil {
+		sendErrorResponse(w, "error processing message", http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(w, true)
+}
+
+// handleInbound applies the keyword in an SMS from phone, if any, to the
+// subscribers with that phone number.
+func handleInbound(ctx context.Context, app *App, source, from, body string) error {
+	action := app.inbound.keyword(body)
+	if action == "" {
+		metricEvents.WithLabelValues(source+"_inbound", "other").Inc()
+		return nil
+	}
+	metricEvents.WithLabelValues(source+"_inbound", action).Inc()
+
+	if from == "" {
+		return nil
+	}
+	number, err := phone.Normalize(from, "")
+	if err != nil {
+		app.logger.ErrorWith("invalid inbound sms sender").String("phone", from).Err("err", err).Write()
+		return nil
+	}
+
+	// Subscribers' numbers may be stored in any format, so the candidates
+	// are looked up by their digits and then matched on their E.164 form.
+	subs, err := app.listmonk.QuerySubscribers(ctx, phoneQuery(number, app.inbound.phones))
+	if err != nil {
+		app.logger.ErrorWith("error looking up subscriber").String("phone", number).Err("err", err).Write()
+		return err
+	}
+	subs = matchPhone(subs, number, app.inbound.phones)
+	if len(subs) == 0 {
+		app.logger.InfoWith("no subscriber found for inbound sms").String("phone", number).String("action", action).Write()
+		return nil
+	}
+
+	if err := applyInbound(ctx, app.listmonk, app.inbound.cfg, action, subs); err != nil {
+		app.logger.ErrorWith("error applying inbound sms").String("phone", number).String("action", action).Err("err", err).Write()
+		if listmonk.IsTemporary(err) {
+			return err
+		}
+		return nil
+	}
+
+	app.logger.InfoWith("processed inbound sms").String("phone", number).String("action", action).Int("subscribers", len(subs)).Write()
+	return nil
+}
+
+// phoneQuery returns the listmonk subscriber query of the subscribers whose
+// phone attributes may be the E.164 phone number, ie. have its digits with or
+// without a leading 00, or its national digits with or without the trunk
+// prefix 0 if the number is in the default country.
+func phoneQuery(number string, phones []messenger.PhoneOpt) string {
+	digits := strings.TrimPrefix(number, "+")
+
+	var (
+		conds []string
+		seen  = make(map[string]bool)
+	)
+	for _, o := range phones {
+		cands := []string{digits, "00" + digits}
+		if cc := o.DefaultCountryCode; cc != "" && strings.HasPrefix(digits, cc) {
+			national := strings.TrimPrefix(digits, cc)
+			cands = append(cands, national, "0"+national)
+		}
+
+		for _, path := range o.Attribs() {
+			c := fmt.Sprintf("regexp_replace(%s, '[^0-9]', '', 'g') IN ('%s')", attribExpr(path), strings.Join(cands, "','"))
+			if !seen[c] {
+				seen[c] = true
+				conds = append(conds, c)
+			}
+		}
+	}
+
+	return strings.Join(conds, " OR ")
+}
+
+// attribExpr returns the SQL expression of the subscriber attribute at path
+// as text.
+func attribExpr(path string) string {
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "'", "''")
+
+	keys := messenger.AttribKeys(path)
+	for i, k := range keys {
+		keys[i] = `"` + esc.Replace(k) + `"`
+	}
+	return fmt.Sprintf("subscribers.attribs #>> '{%s}'", strings.Join(keys, ","))
+}
+
+// matchPhone returns the subscribers with a phone number that, as read in any
+// of the ways in phones, is the E.164 phone number.
+func matchPhone(subs []listmonk.Subscriber, number string, phones []messenger.PhoneOpt) []listmonk.Subscriber {
+	out := subs[:0]
+	for _, s := range subs {
+		for _, o := range phones {
+			if p, err := o.Phone(s.Attribs); err == nil && p == number {
+				out = append(out, s)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// applyInbound opts subscribers out or in.
+func applyInbound(ctx context.Context, lm *listmonk.Client, cfg inboundCfg, action string, subs []listmonk.Subscriber) error {
+	ids := make([]int, 0, len(subs))
+	for _, s := range subs {
+		// Blocklisted subscribers have to be re-enabled in listmonk.
+		if action == inboundOptIn && s.Status == listmonk.SubscriberBlocklisted {
+			continue
+		}
+		ids = append(ids, s.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if action == inboundOptOut && cfg.Action == inboundBlocklist {
+		return lm.BlocklistSubscribers(ctx, ids)
+	}
+
+	// Opt out of, or back into, the configured lists or else the lists
+	// subscribers have, or opted out of, one subscriber at a time as they may
+	// differ.
+	for _, s := range subs {
+		if !inIDs(s.ID, ids) {
+			continue
+		}
+
+		var err error
+		switch {
+		case len(cfg.ListIDs) > 0 && action == inboundOptOut:
+			err = lm.UnsubscribeLists(ctx, []int{s.ID}, cfg.ListIDs)
+		case len(cfg.ListIDs) > 0:
+			err = lm.SubscribeLists(ctx, []int{s.ID}, cfg.ListIDs)
+		case action == inboundOptOut:
+			err = optOutLists(ctx, lm, s)
+		default:
+			err = optInLists(ctx, lm, s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// optOutLists unsubscribes s from the lists it's subscribed to and records
+// them in its attributes. They are recorded first so that they aren't lost if
+// unsubscribing fails and the message is retried.
+func optOutLists(ctx context.Context, lm *listmonk.Client, s listmonk.Subscriber) error {
+	var lists []int
+	for _, l := range s.Lists {
+		if l.SubscriptionStatus != listmonk.SubscriptionUnsubscribed {
+			lists = append(lists, l.ID)
+		}
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	optedOut := optedOutLists(s)
+	for _, id := range lists {
+		if !inIDs(id, optedOut) {
+			optedOut = append(optedOut, id)
+		}
+	}
+	if err := lm.UpdateAttribs(ctx, s, withAttrib(s.Attribs, optOutListsAttrib, optedOut)); err != nil {
+		return err
+	}
+	return lm.UnsubscribeLists(ctx, []int{s.ID}, lists)
+}
+
+// optInLists resubscribes s to the lists an SMS opt-out unsubscribed it from,
+// leaving those it unsubscribed from otherwise.
+func optInLists(ctx context.Context, lm *listmonk.Client, s listmonk.Subscriber) error {
+	lists := optedOutLists(s)
+	if len(lists) == 0 {
+		return nil
+	}
+
+	if err := lm.SubscribeLists(ctx, []int{s.ID}, lists); err != nil {
+		return err
+	}
+	return lm.UpdateAttribs(ctx, s, withAttrib(s.Attribs, optOutListsAttrib, nil))
+}
+
+// optedOutLists returns the lists recorded in s's attributes by an SMS
+// opt-out.
+func optedOutLists(s listmonk.Subscriber) []int {
+	v, _ := s.Attribs[optOutListsAttrib].([]interface{})
+
+	var ids []int
+	for _, id := range v {
+		if f, ok := id.(float64); ok {
+			ids = append(ids, int(f))
+		}
+	}
+	return ids
+}
+
+// withAttrib returns a copy of attribs with the attribute key set to v, or
+// deleted if v is nil.
+func withAttrib(attribs map[string]interface{}, key string, v []int) map[string]interface{} {
+	out := make(map[string]interface{}, len(attribs)+1)
+	for k, a := range attribs {
+		out[k] = a
+	}
+	if v == nil {
+		delete(out, key)
+	} else {
+		out[key] = v
+	}
+	return out
+}
+
+func inIDs(id int, ids []int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+func TestInboundKeyword(t *testing.T) {
+	in, err := newInbound(inboundCfg{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for body, want := range map[string]string{
+		"STOP":        inboundOptOut,
+		" stop. ":     inboundOptOut,
+		"Arrêt":       inboundOptOut,
+		"start!":      inboundOptIn,
+		"please stop": "",
+		"yes":         "",
+		"thanks":      "",
+	} {
+		if got := in.keyword(body); got != want {
+			t.Errorf("%q: got %q, want %q", body, got, want)
+		}
+	}
+
+	if _, err := newInbound(inboundCfg{OptOutKeywords: []string{"STOP"}, OptInKeywords: []string{"stop"}}, nil); err == nil {
+		t.Error("expected error for conflicting keywords")
+	}
+}
+
+// newTestListmonk returns a client for a stub listmonk API that returns subs
+// for subscriber queries and records the other requests.
+func newTestListmonk(t *testing.T, subs string) (*listmonk.Client, *[]string) {
+	t.Helper()
+
+	var reqs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"data": {"results": ` + subs + `}}`))
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		reqs = append(reqs, r.Method+" "+r.URL.Path+" "+string(b))
+		w.Write([]byte(`{"data": true}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := listmonk.New(listmonk.Opt{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &reqs
+}
+
+func TestHandleTwilioInbound(t *testing.T) {
+	lm, reqs := newTestListmonk(t, `[{"id": 1, "status": "enabled", "attribs": {"phone": "+1 500-555-0006"}, "lists": [{"id": 3}, {"id": 4}]}]`)
+
+	const pubURL = "https://messenger.example.com/inbound/twilio"
+	cfg := inboundCfg{}
+	cfg.Twilio.Enabled = true
+	cfg.Twilio.URL = pubURL
+	cfg.Twilio.AuthToken = "token"
+	in, err := newInbound(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{logger: onelog.New(os.Stderr, 0), listmonk: lm, inbound: in}
+
+	form := url.Values{"From": {"+15005550006"}, "Body": {"Stop"}, "MessageSid": {"SM1"}}
+	req := httptest.NewRequest(http.MethodPost, "/inbound/twilio", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", twilioSign("token", pubURL, form))
+	w := httptest.NewRecorder()
+	wrap(app, handleTwilioInbound)(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Response>") {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	// The lists are recorded, then unsubscribed from.
+	if len(*reqs) != 2 || !strings.HasPrefix((*reqs)[0], "PUT /api/subscribers/1 ") ||
+		!strings.Contains((*reqs)[0], `"sms_opt_out_lists":[3,4]`) || !strings.HasPrefix((*reqs)[1], "PUT /api/subscribers/lists") ||
+		!strings.Contains((*reqs)[1], `"action":"unsubscribe"`) || !strings.Contains((*reqs)[1], `"target_list_ids":[3,4]`) {
+		t.Fatalf("unexpected listmonk requests: %v", *reqs)
+	}
+}
+
+func TestApplyInbound(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		action  string
+		listIDs []int
+		sub     string
+		want    []string
+	}{
+		{
+			name:   "opt-out records the subscribed lists",
+			action: inboundOptOut,
+			sub:    `{"id": 1, "attribs": {"sms_opt_out_lists": [5]}, "lists": [{"id": 3}, {"id": 4, "subscription_status": "unsubscribed"}]}`,
+			want: []string{
+				`PUT /api/subscribers/1 {"attribs":{"sms_opt_out_lists":[5,3]},"lists":[3,4]}`,
+				`PUT /api/subscribers/lists {"action":"unsubscribe","ids":[1],"target_list_ids":[3]}`,
+			},
+		},
+		{
+			name:   "opt-out without subscribed lists",
+			action: inboundOptOut,
+			sub:    `{"id": 1, "lists": [{"id": 4, "subscription_status": "unsubscribed"}]}`,
+		},
+		{
+			name:   "opt-in resubscribes the recorded lists",
+			action: inboundOptIn,
+			sub:    `{"id": 1, "attribs": {"city": "Pune", "sms_opt_out_lists": [3]}, "lists": [{"id": 3, "subscription_status": "unsubscribed"}, {"id": 4, "subscription_status": "unsubscribed"}]}`,
+			want: []string{
+				`PUT /api/subscribers/lists {"action":"add","ids":[1],"status":"confirmed","target_list_ids":[3]}`,
+				`PUT /api/subscribers/1 {"attribs":{"city":"Pune"},"lists":[3,4]}`,
+			},
+		},
+		{
+			name:   "opt-in without an opt-out",
+			action: inboundOptIn,
+			sub:    `{"id": 1, "lists": [{"id": 4, "subscription_status": "unsubscribed"}]}`,
+		},
+		{
+			name:    "opt-in to the configured lists",
+			action:  inboundOptIn,
+			listIDs: []int{7},
+			sub:     `{"id": 1, "lists": [{"id": 4, "subscription_status": "unsubscribed"}]}`,
+			want:    []string{`PUT /api/subscribers/lists {"action":"add","ids":[1],"status":"confirmed","target_list_ids":[7]}`},
+		},
+	} {
+		lm, reqs := newTestListmonk(t, "[]")
+
+		var sub listmonk.Subscriber
+		if err := json.Unmarshal([]byte(c.sub), &sub); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyInbound(context.Background(), lm, inboundCfg{ListIDs: c.listIDs}, c.action, []listmonk.Subscriber{sub}); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		if strings.Join(*reqs, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%s: got requests %v, want %v", c.name, *reqs, c.want)
+		}
+	}
+}
+
+func TestHandleSNSInbound(t *testing.T) {
+	// Numbers stored in any format match the sender, and other subscribers
+	// the lookup returns are left alone.
+	lm, reqs := newTestListmonk(t, `[
+		{"id": 1, "status": "enabled", "attribs": {"phone": "(500) 555-0006"}},
+		{"id": 2, "status": "enabled", "attribs": {"phone": 15005550006}},
+		{"id": 3, "status": "enabled", "attribs": {"phone": "+44 500 555 0006"}},
+		{"id": 4, "status": "enabled"},
+		{"id": 5, "status": "enabled", "attribs": {"contact": {"mobile": "500 555 0006"}}}
+	]`)
+
+	cfg := inboundCfg{Action: inboundBlocklist, DefaultCountryCode: "1"}
+	cfg.SNS = eventsCfg{Enabled: true, SkipVerify: true}
+	in, err := newInbound(cfg, []messenger.PhoneOpt{{PhoneAttribs: []string{"contact.mobile"}, DefaultCountryCode: "+1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{logger: onelog.New(os.Stderr, 0), listmonk: lm, inbound: in}
+
+	sms, _ := json.Marshal(snsSMS{OriginationNumber: "+15005550006", MessageBody: "UNSUBSCRIBE"})
+	msg, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": string(sms)})
+	w := httptest.NewRecorder()
+	wrap(app, handleSNSInbound)(w, httptest.NewRequest(http.MethodPost, "/inbound/sns", strings.NewReader(string(msg))))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	if len(*reqs) != 1 || (*reqs)[0] != `PUT /api/subscribers/blocklist {"ids":[1,2,5]}` {
+		t.Fatalf("unexpected listmonk requests: %v", *reqs)
+	}
+}
+
+func TestPhoneQuery(t *testing.T) {
+	for _, c := range []struct {
+		phones []messenger.PhoneOpt
+		want   string
+	}{
+		{
+			[]messenger.PhoneOpt{{}},
+			`regexp_replace(subscribers.attribs #>> '{"phone"}', '[^0-9]', '', 'g') IN ('919845012345','00919845012345')`,
+		},
+		{
+			[]messenger.PhoneOpt{{DefaultCountryCode: "44"}, {}},
+			`regexp_replace(subscribers.attribs #>> '{"phone"}', '[^0-9]', '', 'g') IN ('919845012345','00919845012345')`,
+		},
+		{
+			[]messenger.PhoneOpt{{DefaultCountryCode: "91", PhoneAttribs: []string{"attribs.contact.mobile", "o'neil"}}},
+			`regexp_replace(subscribers.attribs #>> '{"contact","mobile"}', '[^0-9]', '', 'g') IN ('919845012345','00919845012345','9845012345','09845012345')` +
+				` OR regexp_replace(subscribers.attribs #>> '{"o''neil"}', '[^0-9]', '', 'g') IN ('919845012345','00919845012345','9845012345','09845012345')`,
+		},
+	} {
+		if got := phoneQuery("+919845012345", c.phones); got != c.want {
+			t.Errorf("%+v: got %s, want %s", c.phones, got, c.want)
+		}
+	}
+}
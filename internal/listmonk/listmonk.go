@@ -0,0 +1,248 @@
+// Package listmonk is a client for the parts of the listmonk REST API that
+// the messenger calls back into.
+package listmonk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultConnectTimeout = 5 * time.Second
+)
+
+// Bounce types accepted by listmonk.
+const (
+	BounceSoft      = "soft"
+	BounceHard      = "hard"
+	BounceComplaint = "complaint"
+)
+
+// Opt holds the listmonk API config.
+type Opt struct {
+	// URL is the root URL of the listmonk installation.
+	URL string
+	// APIUser and Token are the credentials of a listmonk API user.
+	APIUser string
+	Token   string
+	// Timeout is the deadline of a whole request, and ConnectTimeout of
+	// establishing the connection.
+	Timeout        time.Duration
+	ConnectTimeout time.Duration
+}
+
+// Client calls the listmonk API.
+type Client struct {
+	opt  Opt
+	root *url.URL
+	c    *http.Client
+}
+
+// Error is an error response from the API.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("listmonk: %s (%d)", e.Message, e.Status)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+}
+
+// IsTemporary reports whether err is a network error or a temporary API
+// error, as opposed to a request listmonk rejected.
+func IsTemporary(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary()
+	}
+	return err != nil
+}
+
+// Bounce is a bounce record. Either Email or SubscriberUUID identifies the
+// subscriber.
+type Bounce struct {
+	Email          string          `json:"email,omitempty"`
+	SubscriberUUID string          `json:"subscriber_uuid,omitempty"`
+	CampaignUUID   string          `json:"campaign_uuid,omitempty"`
+	Source         string          `json:"source"`
+	Type           string          `json:"type"`
+	Meta           json.RawMessage `json:"meta"`
+}
+
+// Subscriber is a listmonk subscriber.
+type Subscriber struct {
+	ID      int                    `json:"id"`
+	UUID    string                 `json:"uuid"`
+	Email   string                 `json:"email"`
+	Name    string                 `json:"name"`
+	Status  string                 `json:"status"`
+	Attribs map[string]interface{} `json:"attribs"`
+	Lists   []struct {
+		ID                 int    `json:"id"`
+		SubscriptionStatus string `json:"subscription_status"`
+	} `json:"lists"`
+}
+
+// Subscriber statuses.
+const (
+	SubscriberEnabled     = "enabled"
+	SubscriberBlocklisted = "blocklisted"
+)
+
+// SubscriptionUnsubscribed is the status of a list a subscriber has
+// unsubscribed from.
+const SubscriptionUnsubscribed = "unsubscribed"
+
+// New returns a client for the listmonk API.
+func New(o Opt) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(o.URL, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url: %s", o.URL)
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = defaultConnectTimeout
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: o.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = o.ConnectTimeout
+
+	return &Client{
+		opt:  o,
+		root: u,
+		c:    &http.Client{Timeout: o.Timeout, Transport: t},
+	}, nil
+}
+
+// RecordBounce records a bounce. listmonk blocklists or deletes the
+// subscriber once the bounce actions configured in its settings apply.
+func (c *Client) RecordBounce(ctx context.Context, b Bounce) error {
+	if len(b.Meta) == 0 {
+		b.Meta = json.RawMessage("{}")
+	}
+	return c.do(ctx, http.MethodPost, "/api/bounces", b, nil)
+}
+
+// QuerySubscribers returns the subscribers matching an SQL expression on the
+// subscribers table, eg. "subscribers.attribs->>'phone' = '+1234'".
+func (c *Client) QuerySubscribers(ctx context.Context, query string) ([]Subscriber, error) {
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("per_page", "all")
+
+	var out struct {
+		Results []Subscriber `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/subscribers?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Results, nil
+}
+
+// BlocklistSubscribers blocklists subscribers, which also unsubscribes them
+// from all their lists.
+func (c *Client) BlocklistSubscribers(ctx context.Context, ids []int) error {
+	return c.do(ctx, http.MethodPut, "/api/subscribers/blocklist", map[string]interface{}{"ids": ids}, nil)
+}
+
+// UpdateAttribs replaces the attributes of the subscriber s, leaving its
+// lists as they are in s.
+func (c *Client) UpdateAttribs(ctx context.Context, s Subscriber, attribs map[string]interface{}) error {
+	// Subscriptions to lists that aren't passed are deleted.
+	lists := make([]int, 0, len(s.Lists))
+	for _, l := range s.Lists {
+		lists = append(lists, l.ID)
+	}
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/api/subscribers/%d", s.ID), map[string]interface{}{
+		"attribs": attribs,
+		"lists":   lists,
+	}, nil)
+}
+
+// UnsubscribeLists unsubscribes subscribers from lists.
+func (c *Client) UnsubscribeLists(ctx context.Context, ids, listIDs []int) error {
+	return c.do(ctx, http.MethodPut, "/api/subscribers/lists", map[string]interface{}{
+		"ids":             ids,
+		"action":          "unsubscribe",
+		"target_list_ids": listIDs,
+	}, nil)
+}
+
+// SubscribeLists (re)subscribes subscribers to lists as confirmed.
+func (c *Client) SubscribeLists(ctx context.Context, ids, listIDs []int) error {
+	return c.do(ctx, http.MethodPut, "/api/subscribers/lists", map[string]interface{}{
+		"ids":             ids,
+		"action":          "add",
+		"target_list_ids": listIDs,
+		"status":          "confirmed",
+	}, nil)
+}
+
+// do sends in as the JSON body of a request to the API and decodes the data
+// field of the response into out, if it's not nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.root.String()+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.opt.APIUser, c.opt.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &e)
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return &Error{Status: resp.StatusCode, Message: e.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, &struct {
+		Data interface{} `json:"data"`
+	}{out})
+}
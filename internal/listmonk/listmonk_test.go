@@ -0,0 +1,130 @@
+package listmonk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestClient returns a client for a stub listmonk API served by h.
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	c, err := New(Opt{URL: srv.URL + "/", APIUser: "api", Token: "secret"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestRecordBounce(t *testing.T) {
+	var got map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != "api" || p != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api/bounces" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &got)
+		w.Write([]byte(`{"data": true}`))
+	})
+
+	err := c.RecordBounce(context.Background(), Bounce{SubscriberUUID: "sub", Source: "ses", Type: BounceHard})
+	if err != nil {
+		t.Fatalf("RecordBounce: %v", err)
+	}
+	if got["subscriber_uuid"] != "sub" || got["type"] != "hard" || got["meta"] == nil {
+		t.Fatalf("unexpected bounce: %v", got)
+	}
+}
+
+func TestQuerySubscribers(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("query"); q != "subscribers.attribs->>'phone' = '+1'" {
+			t.Errorf("unexpected query: %s", q)
+		}
+		w.Write([]byte(`{"data": {"results": [{"id": 1, "uuid": "sub", "status": "enabled", "lists": [{"id": 2, "subscription_status": "confirmed"}]}]}}`))
+	})
+
+	subs, err := c.QuerySubscribers(context.Background(), "subscribers.attribs->>'phone' = '+1'")
+	if err != nil {
+		t.Fatalf("QuerySubscribers: %v", err)
+	}
+	if len(subs) != 1 || subs[0].ID != 1 || len(subs[0].Lists) != 1 || subs[0].Lists[0].ID != 2 {
+		t.Fatalf("unexpected subscribers: %+v", subs)
+	}
+}
+
+func TestUnsubscribeLists(t *testing.T) {
+	var got map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/api/subscribers/lists" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &got)
+		w.Write([]byte(`{"data": true}`))
+	})
+
+	if err := c.UnsubscribeLists(context.Background(), []int{1}, []int{2, 3}); err != nil {
+		t.Fatalf("UnsubscribeLists: %v", err)
+	}
+	if got["action"] != "unsubscribe" || len(got["target_list_ids"].([]interface{})) != 2 {
+		t.Fatalf("unexpected request: %v", got)
+	}
+}
+
+func TestUpdateAttribs(t *testing.T) {
+	var got string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = r.Method + " " + r.URL.Path + " " + string(b)
+		w.Write([]byte(`{"data": true}`))
+	})
+
+	var s Subscriber
+	json.Unmarshal([]byte(`{"id": 1, "lists": [{"id": 2}, {"id": 3, "subscription_status": "unsubscribed"}]}`), &s)
+	if err := c.UpdateAttribs(context.Background(), s, map[string]interface{}{"city": "Bengaluru"}); err != nil {
+		t.Fatalf("UpdateAttribs: %v", err)
+	}
+
+	// The subscriber's lists are passed so that they are kept.
+	if want := `PUT /api/subscribers/1 {"attribs":{"city":"Bengaluru"},"lists":[2,3]}`; got != want {
+		t.Fatalf("got request %s, want %s", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	status := http.StatusBadRequest
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "invalid subscriber"}`))
+	})
+
+	err := c.BlocklistSubscribers(context.Background(), []int{1})
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusBadRequest || e.Message != "invalid subscriber" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if IsTemporary(err) {
+		t.Error("expected a 400 not to be temporary")
+	}
+
+	status = http.StatusBadGateway
+	if err := c.BlocklistSubscribers(context.Background(), []int{1}); !IsTemporary(err) {
+		t.Errorf("expected a 502 to be temporary, got %v", err)
+	}
+
+	if _, err := New(Opt{URL: "localhost"}); err == nil {
+		t.Error("expected error for url without scheme")
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketDead = []byte("dead")
+
+// ErrNotFound is returned when a dead-lettered message doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// DeadJob is a message that could not be sent after exhausting its retries.
+type DeadJob struct {
+	ID        uint64            `json:"id"`
+	Messenger string            `json:"messenger"`
+	Message   messenger.Message `json:"message"`
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error"`
+	FailedAt  time.Time         `json:"failed_at"`
+
+	// Raw is the queued job as stored, if it couldn't be decoded.
+	Raw string `json:"raw,omitempty"`
+}
+
+// Bury records a message that failed permanently in the dead-letter store.
+func (q *Queue) Bury(name string, msg messenger.Message, attempts int, sendErr error) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return bury(tx, DeadJob{
+			Messenger: name,
+			Message:   msg,
+			Attempts:  attempts,
+			Error:     sendErr.Error(),
+		})
+	})
+}
+
+// bury adds j to the dead-letter store with a new ID.
+func bury(tx *bolt.Tx, j DeadJob) error {
+	b, err := tx.CreateBucketIfNotExists(bucketDead)
+	if err != nil {
+		return err
+	}
+
+	id, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	j.ID = id
+	j.FailedAt = time.Now()
+	v, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(id), v)
+}
+
+// Dead returns dead-lettered messages, oldest first. If name is not empty, only
+// the messages of that messenger are returned.
+func (q *Queue) Dead(name string) ([]DeadJob, error) {
+	out := []DeadJob{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDead)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var j DeadJob
+			if err := json.Unmarshal(v, &j); err != nil {
+				return err
+			}
+			if name == "" || j.Messenger == name {
+				out = append(out, j)
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+// GetDead returns a single dead-lettered message.
+func (q *Queue) GetDead(id uint64) (DeadJob, error) {
+	var j DeadJob
+	err := q.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDead)
+		if b == nil {
+			return ErrNotFound
+		}
+
+		v := b.Get(itob(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &j)
+	})
+	return j, err
+}
+
+// DeleteDead removes a message from the dead-letter store.
+func (q *Queue) DeleteDead(id uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDead)
+		if b == nil || b.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(itob(id))
+	})
+}
@@ -0,0 +1,417 @@
+// Package queue implements a durable, on-disk FIFO of messages backed by an
+// embedded bbolt database. Every messenger gets its own queue which is drained
+// by a pool of workers. Messages being processed are tracked separately so that
+// anything in flight during a crash is re-queued on the next start.
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketPending  = []byte("pending")
+	bucketInflight = []byte("inflight")
+
+	// pollInterval is how often idle workers check the queue in case a
+	// notification was missed.
+	pollInterval = time.Second
+
+	// drainInterval is how often Drain checks if the queues are empty.
+	drainInterval = 100 * time.Millisecond
+)
+
+// Job is a queued message.
+type Job struct {
+	ID        uint64            `json:"id"`
+	Messenger string            `json:"messenger"`
+	Message   messenger.Message `json:"message"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Queue is a durable message queue.
+type Queue struct {
+	db *bolt.DB
+
+	mu      sync.Mutex
+	signals map[string]chan struct{}
+
+	// OnError, if set, is called with the errors the workers of the named
+	// messenger run into, which they can't return. op is "claim", "ack" or
+	// "nack", or "decode" for a job that couldn't be decoded and was moved to
+	// the dead-letter store. Set it before calling Run.
+	OnError func(name, op string, err error)
+}
+
+// Open opens (or creates) the queue database at path. Jobs that were in flight
+// when the database was last closed are moved back to their pending queues.
+func Open(path string) (*Queue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		db:      db,
+		signals: make(map[string]chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Close closes the underlying database.
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Push appends messages to the named messenger's queue.
+func (q *Queue) Push(name string, msgs ...messenger.Message) error {
+	return q.PushBatch(map[string][]messenger.Message{name: msgs})
+}
+
+// PushBatch appends messages to the queues of several messengers, keyed by
+// name. Either all of them are queued or none are.
+func (q *Queue) PushBatch(batch map[string][]messenger.Message) error {
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for name, msgs := range batch {
+			b, err := subBucket(tx, bucketPending, name)
+			if err != nil {
+				return err
+			}
+
+			for _, m := range msgs {
+				id, err := b.NextSequence()
+				if err != nil {
+					return err
+				}
+
+				j, err := json.Marshal(Job{
+					ID:        id,
+					Messenger: name,
+					Message:   m,
+					CreatedAt: now,
+				})
+				if err != nil {
+					return err
+				}
+				if err := b.Put(itob(id), j); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range batch {
+		q.notify(name)
+	}
+	return nil
+}
+
+// Len returns the number of pending messages in the named messenger's queue.
+func (q *Queue) Len(name string) (int, error) {
+	var n int
+	err := q.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketPending)
+		if b == nil {
+			return nil
+		}
+		if sub := b.Bucket([]byte(name)); sub != nil {
+			n = sub.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Run starts workers that drain the named messenger's queue by calling fn
+// for each job. A job is removed from the queue once fn returns nil; fn is
+// responsible for handling send failures. If fn returns an error, eg. because
+// it was interrupted by a shutdown, the job is put back in the queue. Run
+// blocks until ctx is cancelled and all the workers have finished their
+// current job.
+func (q *Queue) Run(ctx context.Context, name string, workers int, fn func(Job) error) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, name, fn)
+		}()
+	}
+	wg.Wait()
+}
+
+// work claims and processes jobs until ctx is cancelled.
+func (q *Queue) work(ctx context.Context, name string, fn func(Job) error) {
+	sig := q.signal(name)
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		j, ok, err := q.claim(name)
+		if err != nil {
+			q.report(name, "claim", err)
+		}
+		if err != nil || !ok {
+			// Nothing to do. Wait for new messages.
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+			case <-t.C:
+			}
+			continue
+		}
+
+		if err := fn(j); err != nil {
+			if err := q.nack(name, j.ID); err != nil {
+				q.report(name, "nack", err)
+			}
+			continue
+		}
+		if err := q.ack(name, j.ID); err != nil {
+			// The job stays in flight and is sent again on the next start.
+			q.report(name, "ack", err)
+		}
+	}
+}
+
+// report passes a worker's error to OnError.
+func (q *Queue) report(name, op string, err error) {
+	if q.OnError != nil {
+		q.OnError(name, op, err)
+	}
+}
+
+// Drain blocks until the named messengers' queues are empty and none of their
+// jobs are being processed, or until ctx is done.
+func (q *Queue) Drain(ctx context.Context, names ...string) error {
+	t := time.NewTicker(drainInterval)
+	defer t.Stop()
+
+	for {
+		n, err := q.count(names)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// count returns the number of pending and in-flight jobs of the named
+// messengers.
+func (q *Queue) count(names []string) (int, error) {
+	var n int
+	err := q.db.View(func(tx *bolt.Tx) error {
+		for _, parent := range [][]byte{bucketPending, bucketInflight} {
+			p := tx.Bucket(parent)
+			if p == nil {
+				continue
+			}
+			for _, name := range names {
+				if b := p.Bucket([]byte(name)); b != nil {
+					n += b.Stats().KeyN
+				}
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// claim moves the oldest pending job to the in-flight bucket and returns it.
+// Jobs that can't be decoded are moved to the dead-letter store so that they
+// don't block the queue.
+func (q *Queue) claim(name string) (Job, bool, error) {
+	var (
+		j    Job
+		ok   bool
+		dead []error
+	)
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		dead = nil
+		b, err := subBucket(tx, bucketPending, name)
+		if err != nil {
+			return err
+		}
+
+		for {
+			k, v := b.Cursor().First()
+			if k == nil {
+				return nil
+			}
+
+			j = Job{}
+			if err := json.Unmarshal(v, &j); err != nil {
+				dead = append(dead, fmt.Errorf("job %d moved to the dead-letter store: %w", binary.BigEndian.Uint64(k), err))
+				if err := bury(tx, DeadJob{Messenger: name, Error: err.Error(), Raw: string(v)}); err != nil {
+					return err
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+
+			inf, err := subBucket(tx, bucketInflight, name)
+			if err != nil {
+				return err
+			}
+			if err := inf.Put(k, v); err != nil {
+				return err
+			}
+			ok = true
+			return b.Delete(k)
+		}
+	})
+	if err != nil {
+		return j, false, err
+	}
+
+	for _, e := range dead {
+		q.report(name, "decode", e)
+	}
+	return j, ok, nil
+}
+
+// ack removes a processed job from the in-flight bucket.
+func (q *Queue) ack(name string, id uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b, err := subBucket(tx, bucketInflight, name)
+		if err != nil {
+			return err
+		}
+		return b.Delete(itob(id))
+	})
+}
+
+// nack moves an in-flight job back to the front of the pending queue.
+func (q *Queue) nack(name string, id uint64) error {
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		inf, err := subBucket(tx, bucketInflight, name)
+		if err != nil {
+			return err
+		}
+
+		v := inf.Get(itob(id))
+		if v == nil {
+			return nil
+		}
+
+		b, err := subBucket(tx, bucketPending, name)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(itob(id), v); err != nil {
+			return err
+		}
+		return inf.Delete(itob(id))
+	})
+	if err != nil {
+		return err
+	}
+
+	q.notify(name)
+	return nil
+}
+
+// recover moves all in-flight jobs back to their pending queues.
+func (q *Queue) recover() error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		inf, err := tx.CreateBucketIfNotExists(bucketInflight)
+		if err != nil {
+			return err
+		}
+
+		var names []string
+		if err := inf.ForEachBucket(func(name []byte) error {
+			names = append(names, string(name))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			b, err := subBucket(tx, bucketPending, name)
+			if err != nil {
+				return err
+			}
+
+			if err := inf.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				return b.Put(k, v)
+			}); err != nil {
+				return err
+			}
+			if err := inf.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// signal returns the channel used to wake up the named messenger's workers.
+func (q *Queue) signal(name string) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch, ok := q.signals[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		q.signals[name] = ch
+	}
+	return ch
+}
+
+// notify wakes up an idle worker of the named messenger, if any.
+func (q *Queue) notify(name string) {
+	select {
+	case q.signal(name) <- struct{}{}:
+	default:
+	}
+}
+
+// subBucket returns the named child of a top level bucket, creating both if
+// they don't exist.
+func subBucket(tx *bolt.Tx, parent []byte, name string) (*bolt.Bucket, error) {
+	p, err := tx.CreateBucketIfNotExists(parent)
+	if err != nil {
+		return nil, err
+	}
+	return p.CreateBucketIfNotExists([]byte(name))
+}
+
+// itob encodes a sequence number as a sortable key.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
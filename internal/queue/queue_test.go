@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/listmonk/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTest(t *testing.T, path string) *Queue {
+	t.Helper()
+	q, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return q
+}
+
+func testMsg(uuid string) messenger.Message {
+	return messenger.Message{
+		Subject: "hello",
+		Body:    []byte("body"),
+		Subscriber: models.Subscriber{
+			UUID:    uuid,
+			Attribs: models.SubscriberAttribs{"phone": "+919845012345"},
+		},
+		Campaign: &models.Campaign{UUID: "camp", Tags: []string{"sms"}},
+	}
+}
+
+func TestRunDrainsInOrder(t *testing.T) {
+	q := openTest(t, filepath.Join(t.TempDir(), "q.db"))
+	defer q.Close()
+
+	if err := q.Push("sms", testMsg("a"), testMsg("b"), testMsg("c")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if n, _ := q.Len("sms"); n != 3 {
+		t.Fatalf("expected 3 pending, got %d", n)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, "sms", 1, func(j Job) error {
+			mu.Lock()
+			got = append(got, j.Message.Subscriber.UUID)
+			if len(got) == 3 {
+				stop()
+			}
+			mu.Unlock()
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out draining queue")
+	}
+
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("unexpected order: %v", got)
+	}
+	if n, _ := q.Len("sms"); n != 0 {
+		t.Fatalf("expected empty queue, got %d", n)
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	q := openTest(t, filepath.Join(t.TempDir(), "q.db"))
+	defer q.Close()
+
+	if err := q.PushBatch(map[string][]messenger.Message{
+		"sms":   {testMsg("a"), testMsg("b")},
+		"email": {testMsg("c")},
+	}); err != nil {
+		t.Fatalf("PushBatch: %v", err)
+	}
+	for name, want := range map[string]int{"sms": 2, "email": 1} {
+		if n, _ := q.Len(name); n != want {
+			t.Errorf("%s: expected %d pending, got %d", name, want, n)
+		}
+	}
+
+	// A failed batch queues nothing.
+	if err := q.PushBatch(map[string][]messenger.Message{
+		"sms":   {testMsg("d")},
+		"email": {{Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"bad": make(chan int)}}}},
+	}); err == nil {
+		t.Fatal("expected error for a message that can't be encoded")
+	}
+	if n, _ := q.Len("sms"); n != 2 {
+		t.Errorf("expected the failed batch not to be queued, got %d pending", n)
+	}
+}
+
+// TestRecoverInflight checks that a job claimed but never acked is re-queued
+// when the database is reopened.
+func TestRecoverInflight(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "q.db")
+
+	q := openTest(t, path)
+	if err := q.Push("sms", testMsg("a")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if _, ok, err := q.claim("sms"); !ok || err != nil {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	if n, _ := q.Len("sms"); n != 0 {
+		t.Fatalf("expected no pending jobs after claim, got %d", n)
+	}
+	q.Close()
+
+	q = openTest(t, path)
+	defer q.Close()
+
+	j, ok, err := q.claim("sms")
+	if !ok || err != nil {
+		t.Fatalf("expected recovered job: %v %v", ok, err)
+	}
+	if j.Message.Subscriber.UUID != "a" || j.Message.Subscriber.Attribs["phone"] != "+919845012345" {
+		t.Fatalf("unexpected job: %+v", j.Message.Subscriber)
+	}
+	if j.Message.Campaign == nil || j.Message.Campaign.UUID != "camp" {
+		t.Fatalf("campaign not preserved: %+v", j.Message.Campaign)
+	}
+}
+
+func TestClaimUndecodable(t *testing.T) {
+	q := openTest(t, filepath.Join(t.TempDir(), "q.db"))
+	defer q.Close()
+
+	var errs []string
+	q.OnError = func(name, op string, err error) {
+		errs = append(errs, name+" "+op)
+	}
+
+	if err := q.db.Update(func(tx *bolt.Tx) error {
+		b, err := subBucket(tx, bucketPending, "sms")
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(0), []byte("{bad"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push("sms", testMsg("a")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	j, ok, err := q.claim("sms")
+	if !ok || err != nil {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	if j.Message.Subscriber.UUID != "a" {
+		t.Fatalf("expected the next job to be claimed, got %+v", j.Message.Subscriber)
+	}
+	if len(errs) != 1 || errs[0] != "sms decode" {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	dead, err := q.Dead("sms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Raw != "{bad" || dead[0].Error == "" {
+		t.Fatalf("expected the undecodable job to be dead-lettered, got %+v", dead)
+	}
+}
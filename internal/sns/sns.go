@@ -0,0 +1,271 @@
+// Package sns parses and verifies the HTTP notifications Amazon SNS sends to
+// subscribed endpoints.
+package sns
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types.
+const (
+	TypeNotification             = "Notification"
+	TypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	TypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// DefaultCertHost matches the hosts SNS serves its signing certificates from.
+const DefaultCertHost = `^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`
+
+// ErrInvalidSignature is returned for messages that fail verification.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Message is an SNS HTTP(S) message.
+type Message struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+// VerifierOpt configures where signing certificates come from.
+type VerifierOpt struct {
+	// CertFile is a PEM certificate to verify all messages with. If it's
+	// empty, the certificate in each message's SigningCertURL is fetched.
+	CertFile string
+	// CertHost is the pattern the host of SigningCertURL must match.
+	// Defaults to DefaultCertHost.
+	CertHost string
+	// Client fetches certificates and confirms subscriptions.
+	Client *http.Client
+}
+
+// Verifier verifies SNS message signatures.
+type Verifier struct {
+	cert     *x509.Certificate
+	certHost *regexp.Regexp
+	client   *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewVerifier returns a verifier for the config.
+func NewVerifier(o VerifierOpt) (*Verifier, error) {
+	if o.CertHost == "" {
+		o.CertHost = DefaultCertHost
+	}
+	re, err := regexp.Compile(o.CertHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cert host pattern: %v", err)
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	v := &Verifier{
+		certHost: re,
+		client:   o.Client,
+		certs:    map[string]*x509.Certificate{},
+	}
+
+	if o.CertFile != "" {
+		b, err := os.ReadFile(o.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		if v.cert, err = parseCert(b); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", o.CertFile, err)
+		}
+	}
+
+	return v, nil
+}
+
+// Verify checks the signature of m.
+func (v *Verifier) Verify(ctx context.Context, m Message) error {
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unknown signature version %q", ErrInvalidSignature, m.SignatureVersion)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	cert := v.cert
+	if cert == nil {
+		if cert, err = v.getCert(ctx, m.SigningCertURL); err != nil {
+			return err
+		}
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: unsupported certificate key", ErrInvalidSignature)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		h := sha1.Sum([]byte(m.StringToSign()))
+		digest = h[:]
+	} else {
+		h := sha256.Sum256([]byte(m.StringToSign()))
+		digest = h[:]
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Confirm confirms a subscription by visiting its SubscribeURL.
+func (v *Verifier) Confirm(ctx context.Context, m Message) error {
+	if err := v.checkURL(m.SubscribeURL); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.SubscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error confirming subscription: %s", resp.Status)
+	}
+	return nil
+}
+
+// StringToSign returns the canonical form of m that SNS signs.
+func (m Message) StringToSign() string {
+	var fields [][2]string
+	switch m.Type {
+	case TypeNotification:
+		fields = [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", m.Timestamp}, [2]string{"TopicArn", m.TopicARN}, [2]string{"Type", m.Type})
+	default:
+		fields = [][2]string{
+			{"Message", m.Message}, {"MessageId", m.MessageID}, {"SubscribeURL", m.SubscribeURL},
+			{"Timestamp", m.Timestamp}, {"Token", m.Token}, {"TopicArn", m.TopicARN}, {"Type", m.Type},
+		}
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+// Parse decodes an SNS message from the request body.
+func Parse(r io.Reader) (Message, error) {
+	var m Message
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, err
+	}
+	if m.Type == "" {
+		return m, errors.New("missing message type")
+	}
+	return m, nil
+}
+
+// getCert returns the certificate at u, fetching it on first use.
+func (v *Verifier) getCert(ctx context.Context, u string) (*x509.Certificate, error) {
+	if err := v.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	c, ok := v.certs[u]
+	v.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching certificate: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if c, err = parseCert(b); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.certs[u] = c
+	v.mu.Unlock()
+	return c, nil
+}
+
+// checkURL ensures u is an https URL on an SNS host, so that forged messages
+// can't point to certificates or confirmation URLs elsewhere.
+func (v *Verifier) checkURL(u string) error {
+	p, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url", ErrInvalidSignature)
+	}
+	if p.Scheme != "https" || !v.certHost.MatchString(p.Hostname()) {
+		return fmt.Errorf("%w: untrusted url %s", ErrInvalidSignature, u)
+	}
+	return nil
+}
+
+func parseCert(b []byte) (*x509.Certificate, error) {
+	p, _ := pem.Decode(b)
+	if p == nil {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(p.Bytes)
+}
@@ -0,0 +1,119 @@
+package sns
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newSigner returns a key and its self-signed certificate in PEM.
+func newSigner(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, m *Message) {
+	t.Helper()
+	m.SignatureVersion = "2"
+	h := sha256.Sum256([]byte(m.StringToSign()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyCertFile(t *testing.T) {
+	key, cert := newSigner(t)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(path, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(VerifierOpt{CertFile: path})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	m := Message{
+		Type:      TypeNotification,
+		MessageID: "1",
+		TopicARN:  "arn:aws:sns:us-east-1:123456789012:ses",
+		Message:   `{"notificationType": "Bounce"}`,
+		Timestamp: "2024-01-01T00:00:00.000Z",
+	}
+	sign(t, key, &m)
+	if err := v.Verify(context.Background(), m); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	m.Message = `{"notificationType": "Delivery"}`
+	if err := v.Verify(context.Background(), m); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature for tampered message, got %v", err)
+	}
+}
+
+func TestVerifyCertURL(t *testing.T) {
+	key, cert := newSigner(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(cert)
+	}))
+	defer srv.Close()
+
+	v, err := NewVerifier(VerifierOpt{CertHost: `^127\.0\.0\.1$`, Client: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	m := Message{
+		Type:           TypeSubscriptionConfirmation,
+		MessageID:      "1",
+		Token:          "token",
+		TopicARN:       "arn:aws:sns:us-east-1:123456789012:ses",
+		Message:        "You have chosen to subscribe",
+		SubscribeURL:   srv.URL + "/confirm",
+		Timestamp:      "2024-01-01T00:00:00.000Z",
+		SigningCertURL: srv.URL + "/cert.pem",
+	}
+	sign(t, key, &m)
+	if err := v.Verify(context.Background(), m); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := v.Confirm(context.Background(), m); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	// Certificates outside the trusted hosts are rejected.
+	v, _ = NewVerifier(VerifierOpt{Client: srv.Client()})
+	if err := v.Verify(context.Background(), m); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected untrusted cert url to fail, got %v", err)
+	}
+}
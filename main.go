@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/francoispqt/onelog"
 	"github.com/go-chi/chi"
+	"github.com/joeirimpan/listmonk-messenger/internal/listmonk"
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
+	"github.com/joeirimpan/listmonk-messenger/internal/sns"
 	"github.com/joeirimpan/listmonk-messenger/messenger"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
+	"github.com/twilio/twilio-go/client"
 )
 
 var (
@@ -25,14 +40,101 @@ var (
 	buildString = "unknown"
 )
 
+const (
+	defaultShutdownTimeout = 30 * time.Second
+
+	// responseMargin is the part of the server's write timeout kept for
+	// writing the response of a webhook once its pushes are done.
+	responseMargin = 500 * time.Millisecond
+)
+
 type MessengerCfg struct {
-	Config string `koanf:"config"`
+	// Type is the messenger backend, eg. "ses". It defaults to the name of the
+	// config section, which allows several instances of the same backend
+	// under different names.
+	Type   string      `koanf:"type"`
+	Config string      `koanf:"config"`
+	Retry  retryPolicy `koanf:"retry"`
+	// Timeout is the deadline of a single push attempt. 0 means no deadline.
+	Timeout time.Duration `koanf:"timeout"`
+	// Auth overrides the global webhook auth config for this messenger.
+	Auth *authCfg `koanf:"auth"`
+	// RateLimit limits the rate messages are pushed at.
+	RateLimit rateLimitCfg `koanf:"rate_limit"`
+	// BodyTemplate is an optional text/template message bodies are rendered
+	// with before being pushed.
+	BodyTemplate string `koanf:"body_template"`
+
+	// Messengers are the members of a virtual messenger, eg. "failover".
+	Messengers     []string   `koanf:"messengers"`
+	CircuitBreaker breakerCfg `koanf:"circuit_breaker"`
+	// Policy is the success policy of a "fanout" messenger, "all" or "any".
+	Policy string `koanf:"policy"`
+}
+
+// msgrOpts holds the delivery options of a loaded messenger.
+type msgrOpts struct {
+	retry   retryPolicy
+	timeout time.Duration
+	auth    *authCfg
+	limiter *rateLimiter
+	// phone is how the messenger reads subscriber phone numbers. Virtual
+	// messengers read them as their first member does.
+	phone messenger.PhoneOpt
 }
 
 type App struct {
 	logger *onelog.Logger
 
+	// auth is the webhook auth config used unless a messenger overrides it.
+	auth *authCfg
+
+	// concurrency is the max number of recipients of a postback pushed at once.
+	concurrency int
+
+	// pushDeadline is the time the pushes of a webhook request, with their
+	// retries and rate limit waits, have to finish in so that the response is
+	// written within the server's write timeout. 0 means no deadline.
+	pushDeadline time.Duration
+
 	messengers map[string]messenger.Messenger
+
+	// opts holds the delivery options of each messenger.
+	opts map[string]msgrOpts
+
+	// queue, when enabled, buffers messages on disk to be pushed asynchronously.
+	queue *queue.Queue
+
+	// deadLetter, when enabled, records messages that exhausted their retries.
+	deadLetter *queue.Queue
+
+	// workers tracks the queue workers. stopWorkers stops them from picking up
+	// new jobs and abortPushes cancels the pushes they are making.
+	workers     sync.WaitGroup
+	stopWorkers context.CancelFunc
+	abortPushes context.CancelFunc
+
+	// requests tracks the webhook requests being handled and abortRequests
+	// cancels their contexts, and with them the pushes they are making.
+	requests      sync.WaitGroup
+	abortRequests context.CancelFunc
+
+	// listmonk is the client for calling back into listmonk, if configured.
+	listmonk *listmonk.Client
+
+	// sesEvents, when enabled, receives SES notifications at /events/ses.
+	sesEvents *sesEvents
+
+	// twilioEvents, when enabled, receives Twilio status callbacks at
+	// /events/twilio.
+	twilioEvents *twilioEvents
+
+	// inbound, when enabled, handles opt-out and opt-in SMS replies.
+	inbound *inbound
+
+	// router, when enabled, picks the messenger of each recipient of
+	// postbacks to /webhook/route.
+	router *router
 }
 
 func init() {
@@ -44,7 +146,8 @@ func init() {
 	f.StringSlice("config", []string{"config.toml"},
 		"Path to one or more TOML config files to load in order")
 	f.StringSlice("msgr", []string{"pinpoint"},
-		"Name of messenger. Can specify multiple values.")
+		fmt.Sprintf("Name of messenger config to load. Can specify multiple values. "+
+			"Use 'help' to list the available types. Types: %s", strings.Join(messenger.Types(), ", ")))
 	f.Bool("version", false, "Show build version")
 	if err := f.Parse(os.Args[1:]); err != nil {
 		log.Fatalf("error parsing flags: %v", err)
@@ -56,6 +159,14 @@ func init() {
 		os.Exit(0)
 	}
 
+	// List the available messenger types.
+	if msgrs, _ := f.GetStringSlice("msgr"); len(msgrs) == 1 && msgrs[0] == "help" {
+		for _, t := range messenger.Types() {
+			fmt.Println(t)
+		}
+		os.Exit(0)
+	}
+
 	// Read the config files.
 	cFiles, _ := f.GetStringSlice("config")
 	for _, f := range cFiles {
@@ -70,40 +181,298 @@ func init() {
 	}
 }
 
-// loadMessengers loads all messages mentioned in posflag into application.
-func loadMessengers(msgrs []string, app *App) {
+// messengerCfg reads the [messenger.<name>] config section.
+func messengerCfg(name string) (MessengerCfg, error) {
+	var cfg MessengerCfg
+	if err := ko.Unmarshal("messenger."+name, &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Type == "" {
+		cfg.Type = name
+	}
+	return cfg, nil
+}
+
+// loadMessengers loads all messengers mentioned in posflag into application.
+// Each one is configured by the [messenger.<name>] section and served at
+// /webhook/<name>. Virtual messengers are loaded last as they are made of the
+// others.
+func loadMessengers(msgrs []string, app *App) error {
 	app.messengers = make(map[string]messenger.Messenger)
+	app.opts = make(map[string]msgrOpts)
 
+	cfgs := make(map[string]MessengerCfg, len(msgrs))
 	for _, m := range msgrs {
-		var cfg MessengerCfg
-		if err := ko.Unmarshal("messenger."+m, &cfg); err != nil {
-			log.Fatalf("error reading %s messenger config: %v", m, err)
+		cfg, err := messengerCfg(m)
+		if err != nil {
+			return fmt.Errorf("error reading %s messenger config: %w", m, err)
 		}
+		cfgs[m] = cfg
+	}
+
+	for _, virtual := range []bool{false, true} {
+		for _, m := range msgrs {
+			cfg := cfgs[m]
+			if isVirtual(cfg.Type) != virtual {
+				continue
+			}
+
+			var (
+				msgr  messenger.Messenger
+				phone messenger.PhoneOpt
+				err   error
+			)
+			if virtual {
+				msgr, err = newVirtual(app, m, cfg)
+				if err == nil && len(cfg.Messengers) > 0 {
+					phone = app.opts[cfg.Messengers[0]].phone
+				}
+			} else {
+				msgr, err = messenger.New(cfg.Type, []byte(cfg.Config), app.logger)
+				if err == nil {
+					phone, err = messenger.ParsePhoneOpt([]byte(cfg.Config))
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("error creating %s messenger: %w", m, err)
+			}
+
+			lim, err := newRateLimiter(cfg.RateLimit)
+			if err != nil {
+				return fmt.Errorf("error reading %s rate limit: %w", m, err)
+			}
 
-		var (
-			msgr messenger.Messenger
-			err  error
-		)
-		switch m {
-		case "pinpoint":
-			msgr, err = messenger.NewPinpoint([]byte(cfg.Config), app.logger)
-		case "ses":
-			msgr, err = messenger.NewAWSSES([]byte(cfg.Config), app.logger)
-		case "twilio":
-			msgr, err = messenger.NewTwilio([]byte(cfg.Config), app.logger)
-		default:
-			log.Fatalf("invalid provider: %s", m)
+			var tpl *template.Template
+			if cfg.BodyTemplate != "" {
+				if tpl, err = parseBodyTemplate(m, cfg.BodyTemplate); err != nil {
+					return fmt.Errorf("error reading %s body template: %w", m, err)
+				}
+			}
+
+			app.messengers[m] = instrument(m, withTemplate(msgr, tpl))
+			app.opts[m] = msgrOpts{
+				retry:   cfg.Retry,
+				timeout: cfg.Timeout,
+				auth:    cfg.Auth,
+				limiter: lim,
+				phone:   phone,
+			}
+			log.Printf("loaded %s (%s)\n", m, cfg.Type)
 		}
+	}
+	return nil
+}
 
-		if err != nil {
-			log.Fatalf("error creating %s messenger: %v", m, err)
+// initRouter loads the routing rules.
+func initRouter(app *App) {
+	var rules []routeRule
+	if err := ko.Unmarshal("routing.rules", &rules); err != nil {
+		log.Fatalf("error reading routing rules: %v", err)
+	}
+
+	rt, err := newRouter(rules, app.messengers, app.opts)
+	if err != nil {
+		log.Fatalf("error loading routing rules: %v", err)
+	}
+	app.router = rt
+}
+
+// initListmonk creates the listmonk API client.
+func initListmonk(app *App) {
+	c, err := listmonk.New(listmonk.Opt{
+		URL:            ko.String("listmonk.url"),
+		APIUser:        ko.String("listmonk.api_user"),
+		Token:          ko.String("listmonk.token"),
+		Timeout:        ko.Duration("listmonk.timeout"),
+		ConnectTimeout: ko.Duration("listmonk.connect_timeout"),
+	})
+	if err != nil {
+		log.Fatalf("error creating listmonk client: %v", err)
+	}
+	app.listmonk = c
+}
+
+// initSESEvents sets up the endpoint receiving SES notifications.
+func initSESEvents(app *App) {
+	var cfg eventsCfg
+	if err := ko.Unmarshal("events.ses", &cfg); err != nil {
+		log.Fatalf("error reading SES events config: %v", err)
+	}
+	if app.listmonk == nil {
+		log.Fatalf("SES events require the [listmonk] config")
+	}
+
+	v, err := sns.NewVerifier(sns.VerifierOpt{CertFile: cfg.CertFile, CertHost: cfg.CertHost})
+	if err != nil {
+		log.Fatalf("error creating SNS verifier: %v", err)
+	}
+	if cfg.SkipVerify {
+		log.Printf("WARNING: SNS signatures of SES events are not verified")
+	}
+
+	app.sesEvents = &sesEvents{cfg: cfg, verifier: v}
+}
+
+// initTwilioEvents sets up the endpoint receiving Twilio status callbacks.
+// Callbacks are validated with the auth token of the twilio messenger sending
+// from their account, or the configured one.
+func initTwilioEvents(app *App) {
+	var cfg twilioEventsCfg
+	if err := ko.Unmarshal("events.twilio", &cfg); err != nil {
+		log.Fatalf("error reading Twilio events config: %v", err)
+	}
+	if app.listmonk == nil {
+		log.Fatalf("Twilio events require the [listmonk] config")
+	}
+	if cfg.URL == "" {
+		log.Fatalf("Twilio events require url")
+	}
+
+	validators := make(map[string]client.RequestValidator)
+	for _, m := range ko.Strings("msgr") {
+		mc, err := messengerCfg(m)
+		if err != nil || mc.Type != "twilio" {
+			continue
 		}
+		var acc twilioAccount
+		if err := json.Unmarshal([]byte(mc.Config), &acc); err != nil {
+			log.Fatalf("error reading %s messenger config: %v", m, err)
+		}
+		validators[acc.AccountID] = client.NewRequestValidator(acc.AuthToken)
+	}
+	if len(validators) == 0 && cfg.AuthToken == "" {
+		log.Fatalf("Twilio events require a twilio messenger or auth_token")
+	}
 
-		app.messengers[m] = msgr
-		log.Printf("loaded %s\n", m)
+	app.twilioEvents = &twilioEvents{
+		cfg:        cfg,
+		validators: validators,
+		states:     newDeliveryStates(cfg.MaxStates),
 	}
 }
 
+// initInbound sets up the endpoints receiving inbound SMS.
+func initInbound(app *App) {
+	var cfg inboundCfg
+	if err := ko.Unmarshal("inbound", &cfg); err != nil {
+		log.Fatalf("error reading inbound config: %v", err)
+	}
+	if app.listmonk == nil {
+		log.Fatalf("inbound SMS requires the [listmonk] config")
+	}
+
+	// Match senders against the numbers of the loaded messengers too.
+	names := make([]string, 0, len(app.opts))
+	for name := range app.opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	phones := make([]messenger.PhoneOpt, 0, len(names))
+	for _, name := range names {
+		phones = append(phones, app.opts[name].phone)
+	}
+
+	in, err := newInbound(cfg, phones)
+	if err != nil {
+		log.Fatalf("error reading inbound config: %v", err)
+	}
+	if cfg.SNS.SkipVerify {
+		log.Printf("WARNING: SNS signatures of inbound SMS are not verified")
+	}
+
+	app.inbound = in
+}
+
+// initQueue opens the on-disk queue database. If the queue is enabled, it
+// starts workers that drain it into every loaded messenger.
+func initQueue(app *App) {
+	q, err := queue.Open(ko.String("queue.path"))
+	if err != nil {
+		log.Fatalf("error opening queue: %v", err)
+	}
+
+	if ko.Bool("dead_letter.enabled") {
+		app.deadLetter = q
+		log.Printf("dead-letter store enabled at %s", ko.String("queue.path"))
+	}
+	if !ko.Bool("queue.enabled") {
+		return
+	}
+
+	workers := ko.Int("queue.workers")
+	if workers < 1 {
+		workers = 1
+	}
+
+	q.OnError = func(name, op string, err error) {
+		app.logger.ErrorWith("queue error").String("messenger", name).String("op", op).Err("err", err).Write()
+		metricQueueErrors.WithLabelValues(name, op).Inc()
+	}
+	startWorkers(app, q, workers)
+
+	names := make([]string, 0, len(app.messengers))
+	for name := range app.messengers {
+		names = append(names, name)
+	}
+	prometheus.MustRegister(queueCollector{q: q, names: names})
+
+	app.queue = q
+	log.Printf("queue enabled at %s with %d workers per messenger", ko.String("queue.path"), workers)
+}
+
+// pushDeadline returns the time webhook pushes have within the server's write
+// timeout, keeping responseMargin, or half of a shorter timeout, for the
+// response.
+func pushDeadline(writeTimeout time.Duration) time.Duration {
+	if writeTimeout <= 0 {
+		return 0
+	}
+	if writeTimeout <= 2*responseMargin {
+		return writeTimeout / 2
+	}
+	return writeTimeout - responseMargin
+}
+
+// shutdown stops the HTTP server, waits for in-flight and queued pushes to
+// finish within the shutdown timeout and then flushes and closes all the
+// messengers. Queued messages that couldn't be sent in time remain on disk.
+func shutdown(app *App, srv *http.Server) {
+	timeout := ko.Duration("server.shutdown_timeout")
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopServer(ctx, app, srv)
+
+	if app.queue != nil {
+		stopWorkers(ctx, app)
+	}
+	closeMessengers(app)
+
+	q := app.queue
+	if q == nil {
+		q = app.deadLetter
+	}
+	if q != nil {
+		if err := q.Close(); err != nil {
+			logger.Printf("error closing queue: %v", err)
+		}
+	}
+}
+
+// stopServer stops accepting webhooks and waits for the in-flight requests.
+// Past ctx's deadline, their pushes are aborted so that none is still running
+// once the messengers are closed.
+func stopServer(ctx context.Context, app *App, srv *http.Server) {
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Printf("error shutting down server: %v", err)
+		app.abortRequests()
+	}
+	app.requests.Wait()
+}
+
 func main() {
 	logLevels := onelog.INFO | onelog.WARN | onelog.ERROR | onelog.FATAL
 	if ko.String("log_level") == "debug" {
@@ -118,24 +487,120 @@ func main() {
 	})
 
 	// load messengers
-	app := &App{logger: l}
+	app := &App{
+		logger:       l,
+		concurrency:  ko.Int("concurrency"),
+		pushDeadline: pushDeadline(ko.Duration("server.write_timeout")),
+	}
+	if app.concurrency < 1 {
+		app.concurrency = 1
+	}
+
+	app.auth = &authCfg{}
+	if err := ko.Unmarshal("auth", app.auth); err != nil {
+		log.Fatalf("error reading auth config: %v", err)
+	}
+
+	if err := loadMessengers(ko.Strings("msgr"), app); err != nil {
+		log.Fatal(err)
+	}
+	for name, o := range app.opts {
+		if !app.auth.enabled() && !o.auth.enabled() {
+			log.Printf("WARNING: /webhook/%s is not authenticated", name)
+		}
+	}
+
+	if ko.Bool("routing.enabled") {
+		initRouter(app)
+
+		// The routing webhook can send with any messenger, so it only
+		// accepts the global credentials.
+		if !app.auth.enabled() {
+			log.Printf("WARNING: /webhook/%s is not authenticated", routeName)
+		}
+	}
 
-	loadMessengers(ko.Strings("msgr"), app)
+	if ko.Bool("queue.enabled") || ko.Bool("dead_letter.enabled") {
+		initQueue(app)
+	}
+
+	if ko.String("listmonk.url") != "" {
+		initListmonk(app)
+	}
+	if ko.Bool("events.ses.enabled") {
+		initSESEvents(app)
+	}
+	if ko.Bool("events.twilio.enabled") {
+		initTwilioEvents(app)
+	}
+	if ko.Bool("inbound.twilio.enabled") || ko.Bool("inbound.sns.enabled") {
+		initInbound(app)
+	}
 
 	r := chi.NewRouter()
 	r.Get("/health", handleHealthCheck)
-	r.Post("/webhook/{provider}", wrap(app, handlePostback))
+	r.Handle("/metrics", promhttp.Handler())
+	r.With(authenticate(app)).Post("/webhook/{provider}", wrap(app, handlePostback))
+	if app.router != nil {
+		r.With(authenticate(app)).Post("/webhook/"+routeName, wrap(app, handleRoute))
+	}
+	if app.sesEvents != nil {
+		// SNS messages are authenticated by their signatures.
+		r.Post("/events/ses", wrap(app, handleSESEvent))
+	}
+	if app.twilioEvents != nil {
+		// Status callbacks are authenticated by X-Twilio-Signature.
+		r.Post("/events/twilio", wrap(app, handleTwilioEvent))
+	}
+	if app.inbound != nil && app.inbound.cfg.Twilio.Enabled {
+		r.Post("/inbound/twilio", wrap(app, handleTwilioInbound))
+	}
+	if app.inbound != nil && app.inbound.cfg.SNS.Enabled {
+		r.Post("/inbound/sns", wrap(app, handleSNSInbound))
+	}
+	// The admin endpoints expose subscribers and can send messages, so they
+	// are only served with the global auth config.
+	if app.auth.enabled() {
+		r.Group(func(r chi.Router) {
+			r.Use(authenticate(app))
+			if app.deadLetter != nil {
+				r.Get("/admin/dead-letter", wrap(app, handleGetDeadLetter))
+				r.Post("/admin/dead-letter/{id}/replay", wrap(app, handleReplayDeadLetter))
+				r.Delete("/admin/dead-letter/{id}", wrap(app, handleDeleteDeadLetter))
+			}
+			if app.twilioEvents != nil {
+				r.Get("/admin/events/twilio/{sid}", wrap(app, handleGetTwilioState))
+			}
+		})
+	} else if app.deadLetter != nil || app.twilioEvents != nil {
+		log.Printf("WARNING: /admin endpoints are disabled as [auth] is not configured")
+	}
 
-	// HTTP Server.
+	// HTTP Server. Requests are handled under a context that shutdown cancels
+	// if they don't finish in time.
+	var baseCtx context.Context
+	baseCtx, app.abortRequests = context.WithCancel(context.Background())
 	srv := &http.Server{
 		Addr:         ko.String("server.address"),
 		ReadTimeout:  ko.Duration("server.read_timeout"),
 		WriteTimeout: ko.Duration("server.write_timeout"),
 		Handler:      r,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
 	}
 
-	logger.Printf("starting on %s", srv.Addr)
-	if err := srv.ListenAndServe(); err != nil {
-		logger.Fatalf("couldn't start server: %v", err)
-	}
+	go func() {
+		logger.Printf("starting on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("couldn't start server: %v", err)
+		}
+	}()
+
+	// Wait for a termination signal and shut down gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	logger.Printf("shutting down")
+	shutdown(app, srv)
+	logger.Printf("bye")
 }
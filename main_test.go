@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/rawbytes"
+)
+
+// cfgMessenger is a messenger that holds the config it was created with.
+type cfgMessenger struct {
+	stubMessenger
+	cfg string
+}
+
+func init() {
+	messenger.Register("cfgtest", func(cfg []byte, l *onelog.Logger) (messenger.Messenger, error) {
+		return &cfgMessenger{stubMessenger: stubMessenger{name: "cfgtest"}, cfg: string(cfg)}, nil
+	})
+}
+
+func TestLoadMessengers(t *testing.T) {
+	defer func(k *koanf.Koanf) { ko = k }(ko)
+
+	for _, c := range []struct {
+		name  string
+		conf  string
+		msgrs []string
+		// want is the config of each loaded messenger, nil if loading fails.
+		want map[string]string
+	}{
+		{
+			name: "type defaults to the section name",
+			conf: `
+[messenger.cfgtest]
+config = '{"id": "a"}'`,
+			msgrs: []string{"cfgtest"},
+			want:  map[string]string{"cfgtest": `{"id": "a"}`},
+		},
+		{
+			name: "instances of the same type",
+			conf: `
+[messenger.otp]
+type = "cfgtest"
+config = '{"id": "a"}'
+
+[messenger.marketing]
+type = "cfgtest"
+config = '{"id": "b"}'`,
+			msgrs: []string{"otp", "marketing"},
+			want:  map[string]string{"otp": `{"id": "a"}`, "marketing": `{"id": "b"}`},
+		},
+		{
+			name: "unknown type",
+			conf: `
+[messenger.otp]
+type = "nope"`,
+			msgrs: []string{"otp"},
+		},
+		{
+			name: "unknown section name without a type",
+			conf: `
+[messenger.otp]
+config = '{"id": "a"}'`,
+			msgrs: []string{"otp"},
+		},
+	} {
+		ko = koanf.New(".")
+		if err := ko.Load(rawbytes.Provider([]byte(c.conf)), toml.Parser()); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		app := &App{logger: onelog.New(os.Stderr, 0)}
+		err := loadMessengers(c.msgrs, app)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		if len(app.messengers) != len(c.want) {
+			t.Errorf("%s: expected %d messengers, got %d", c.name, len(c.want), len(app.messengers))
+		}
+		for name, cfg := range c.want {
+			m, ok := app.messengers[name].(instrumented)
+			if !ok {
+				t.Errorf("%s: %s not loaded", c.name, name)
+				continue
+			}
+			if got := m.Messenger.(*cfgMessenger).cfg; got != cfg {
+				t.Errorf("%s: %s: got config %q, want %q", c.name, name, got, cfg)
+			}
+			if _, ok := app.opts[name]; !ok {
+				t.Errorf("%s: %s has no options", c.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package messenger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// attribPaths are paths of subscriber attributes, tried in order. Nested
+// attributes are separated by dots, eg. "contact.mobile", optionally prefixed
+// with "attribs.".
+type attribPaths []string
+
+func (p attribPaths) validate() error {
+	for _, path := range p {
+		for _, k := range AttribKeys(path) {
+			if k == "" {
+				return fmt.Errorf("invalid attribute path: %q", path)
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the first path set in attribs. Empty strings are
+// considered unset.
+func (p attribPaths) lookup(attribs models.SubscriberAttribs) (interface{}, bool) {
+	for _, path := range p {
+		if v, ok := lookupAttrib(attribs, path); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// lookupString returns the first of the paths set to a non-empty string.
+func (p attribPaths) lookupString(attribs models.SubscriberAttribs) (string, bool) {
+	for _, path := range p {
+		if v, ok := lookupAttrib(attribs, path); ok {
+			if s, ok := v.(string); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+func lookupAttrib(attribs models.SubscriberAttribs, path string) (interface{}, bool) {
+	var v interface{} = map[string]interface{}(attribs)
+	for _, k := range AttribKeys(path) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+
+	if v == nil || v == "" {
+		return nil, false
+	}
+	return v, true
+}
+
+// AttribKeys returns the keys of the nested attributes in an attribute path.
+func AttribKeys(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "attribs."), ".")
+}
@@ -0,0 +1,43 @@
+package messenger
+
+import (
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestAttribPaths(t *testing.T) {
+	attribs := models.SubscriberAttribs{
+		"phone":    "",
+		"whatsapp": float64(919845012345),
+		"contact":  map[string]interface{}{"mobile": "+14155550123", "country": "US"},
+	}
+
+	for _, c := range []struct {
+		paths attribPaths
+		want  interface{}
+	}{
+		{attribPaths{"attribs.contact.mobile"}, "+14155550123"},
+		{attribPaths{"contact.mobile"}, "+14155550123"},
+		{attribPaths{"phone", "whatsapp"}, float64(919845012345)},
+		{attribPaths{"contact.landline", "contact.mobile"}, "+14155550123"},
+		{attribPaths{"whatsapp.number", "contact.country"}, "US"},
+	} {
+		got, ok := c.paths.lookup(attribs)
+		if !ok || got != c.want {
+			t.Errorf("%v: got %v (%v), want %v", c.paths, got, ok, c.want)
+		}
+	}
+
+	for _, p := range []attribPaths{{"phone"}, {"contact"}, {"missing.key"}} {
+		if _, ok := p.lookupString(attribs); ok {
+			t.Errorf("%v: expected no string", p)
+		}
+	}
+
+	for _, p := range []attribPaths{{""}, {"contact..mobile"}, {"attribs."}} {
+		if err := p.validate(); err == nil {
+			t.Errorf("%v: expected error", p)
+		}
+	}
+}
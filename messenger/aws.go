@@ -1,15 +1,19 @@
 package messenger
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-// awsCfg holds the AWS credentials and region shared by the SES and Pinpoint
-// messengers.
+// awsCfg holds the AWS credentials and region shared by the AWS messengers.
 type awsCfg struct {
 	AccessKey string `json:"access_key"`
 	SecretKey string `json:"secret_key"`
@@ -77,3 +81,70 @@ func checkCredentials(sess *session.Session) error {
 	_, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	return err
 }
+
+// awsErrorClasses maps AWS error codes to error classes.
+var awsErrorClasses = map[string]*ErrorClass{
+	"Throttling":                             ErrThrottled,
+	"ThrottlingException":                    ErrThrottled,
+	"ThrottledException":                     ErrThrottled,
+	"TooManyRequestsException":               ErrThrottled,
+	"RequestLimitExceeded":                   ErrThrottled,
+	"RequestThrottled":                       ErrThrottled,
+	"LimitExceededException":                 ErrThrottled,
+	"ProvisionedThroughputExceededException": ErrThrottled,
+
+	"ServiceUnavailable":           ErrProviderOutage,
+	"ServiceUnavailableException":  ErrProviderOutage,
+	"InternalFailure":              ErrProviderOutage,
+	"InternalServerError":          ErrProviderOutage,
+	"InternalServerErrorException": ErrProviderOutage,
+	"InternalServerException":      ErrProviderOutage,
+	"RequestTimeout":               ErrProviderOutage,
+	"RequestTimeoutException":      ErrProviderOutage,
+	// RequestError is returned by the SDK on network failures.
+	"RequestError": ErrProviderOutage,
+
+	"AccessDenied":                ErrAuth,
+	"AccessDeniedException":       ErrAuth,
+	"UnrecognizedClientException": ErrAuth,
+	"InvalidClientTokenId":        ErrAuth,
+	"SignatureDoesNotMatch":       ErrAuth,
+	"ExpiredToken":                ErrAuth,
+	"ExpiredTokenException":       ErrAuth,
+	"NoCredentialProviders":       ErrAuth,
+
+	"MessageRejected": ErrContentRejected,
+}
+
+// classifyAWSError wraps an AWS SDK error in its error class. Errors that
+// can't be classified are returned as is.
+func classifyAWSError(err error) error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return err
+	}
+
+	// The SDK returns RequestCanceled when the request's context is done,
+	// which is classified like the context's error.
+	if awsErr.Code() == request.CanceledErrorCode && awsErr.OrigErr() != nil {
+		return ctxError(awsErr.OrigErr())
+	}
+
+	if c, ok := awsErrorClasses[awsErr.Code()]; ok {
+		return NewError(c, err)
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch s := reqErr.StatusCode(); {
+		case s == http.StatusTooManyRequests:
+			return NewError(ErrThrottled, err)
+		case s == http.StatusUnauthorized || s == http.StatusForbidden:
+			return NewError(ErrAuth, err)
+		case s >= http.StatusInternalServerError:
+			return NewError(ErrProviderOutage, err)
+		}
+	}
+
+	return err
+}
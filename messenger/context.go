@@ -0,0 +1,60 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+)
+
+// ContextMessenger is a Messenger that accepts a context for cancellation and
+// deadlines when pushing a message.
+type ContextMessenger interface {
+	Messenger
+	PushContext(context.Context, Message) error
+}
+
+// WithContext returns m as a ContextMessenger. Messengers that don't implement
+// PushContext are adapted by running Push in the background and abandoning it
+// when the context is done. The underlying call can't be cancelled, but the
+// caller is no longer blocked by it.
+func WithContext(m Messenger) ContextMessenger {
+	if c, ok := m.(ContextMessenger); ok {
+		return c
+	}
+	return contextAdapter{m}
+}
+
+// PushContext pushes msg using m, honouring ctx.
+func PushContext(ctx context.Context, m Messenger, msg Message) error {
+	return WithContext(m).PushContext(ctx, msg)
+}
+
+type contextAdapter struct {
+	Messenger
+}
+
+func (a contextAdapter) PushContext(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return ctxError(err)
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- a.Push(msg)
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctxError(ctx.Err())
+	}
+}
+
+// ctxError classifies a context error. A deadline means the provider took too
+// long to respond.
+func ctxError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewError(ErrProviderOutage, err)
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitMessenger is a messenger without context support whose pushes block
+// until release is closed.
+type waitMessenger struct {
+	release chan struct{}
+}
+
+func (w waitMessenger) Name() string       { return "wait" }
+func (w waitMessenger) Push(Message) error { <-w.release; return nil }
+func (w waitMessenger) Flush() error       { return nil }
+func (w waitMessenger) Close() error       { return nil }
+
+func TestContextAdapter(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	m := WithContext(waitMessenger{release: release})
+
+	// A done context isn't pushed with.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.PushContext(ctx, Message{}); !errors.Is(err, context.Canceled) || Class(err) != nil {
+		t.Errorf("expected an unclassified cancellation, got %v (%v)", err, Class(err))
+	}
+
+	// A push past the deadline is abandoned as a provider outage.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.PushContext(ctx, Message{}); Class(err) != ErrProviderOutage {
+		t.Errorf("expected a provider outage, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the push to be abandoned at the deadline, took %v", d)
+	}
+
+	// A cancelled push is abandoned without a class.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := m.PushContext(ctx, Message{}); !errors.Is(err, context.Canceled) || Class(err) != nil {
+		t.Errorf("expected an unclassified cancellation, got %v (%v)", err, Class(err))
+	}
+}
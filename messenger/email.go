@@ -0,0 +1,46 @@
+package messenger
+
+import "github.com/knadh/smtppool"
+
+// newEmail builds an e-mail to the message's subscriber. The campaign's from
+// address, if any, takes precedence over the message's which is then used as
+// the envelope sender.
+func newEmail(msg Message) smtppool.Email {
+	// convert attachments to smtppool.Attachments
+	var files []smtppool.Attachment
+	if msg.Attachments != nil {
+		files = make([]smtppool.Attachment, 0, len(msg.Attachments))
+		for _, f := range msg.Attachments {
+			a := smtppool.Attachment{
+				Filename: f.Name,
+				Header:   f.Header,
+				Content:  make([]byte, len(f.Content)),
+			}
+			copy(a.Content, f.Content)
+			files = append(files, a)
+		}
+	}
+
+	fromEmail := msg.From
+	if msg.Campaign != nil {
+		fromEmail = msg.Campaign.FromEmail
+	}
+
+	email := smtppool.Email{
+		From:        fromEmail,
+		To:          []string{msg.Subscriber.Email},
+		Subject:     msg.Subject,
+		Sender:      msg.From,
+		Headers:     msg.Headers,
+		Attachments: files,
+	}
+
+	switch {
+	case msg.ContentType == ContentTypePlain:
+		email.Text = msg.Body
+	default:
+		email.HTML = msg.Body
+	}
+
+	return email
+}
@@ -0,0 +1,79 @@
+package messenger
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrorClass is a category of push failure that is common to all providers.
+// Implementations wrap their provider errors in one of the classes below with
+// NewError so that callers can tell them apart using errors.Is or Class.
+type ErrorClass struct {
+	// Code is a machine readable identifier of the class.
+	Code string
+	desc string
+}
+
+func (c *ErrorClass) Error() string {
+	return c.desc
+}
+
+var (
+	// ErrInvalidRecipient means the recipient address or phone is missing,
+	// malformed or cannot receive messages.
+	ErrInvalidRecipient = &ErrorClass{Code: "invalid_recipient", desc: "invalid recipient"}
+
+	// ErrAuth means the provider rejected the configured credentials.
+	ErrAuth = &ErrorClass{Code: "auth_failure", desc: "authentication failure"}
+
+	// ErrThrottled means the provider is rate limiting requests.
+	ErrThrottled = &ErrorClass{Code: "throttled", desc: "throttled"}
+
+	// ErrProviderOutage means the provider failed or could not be reached.
+	ErrProviderOutage = &ErrorClass{Code: "provider_outage", desc: "provider outage"}
+
+	// ErrContentRejected means the provider refused the message content.
+	ErrContentRejected = &ErrorClass{Code: "content_rejected", desc: "content rejected"}
+)
+
+// Error is a provider error tagged with its class.
+type Error struct {
+	Class *ErrorClass
+	Err   error
+}
+
+// NewError wraps err in class.
+func NewError(class *ErrorClass, err error) error {
+	return &Error{Class: class, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Class.desc + ": " + e.Err.Error()
+}
+
+// Unwrap makes both the class and the underlying error visible to errors.Is
+// and errors.As.
+func (e *Error) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// Class returns the class of err, or nil if it hasn't been classified.
+func Class(err error) *ErrorClass {
+	var c *ErrorClass
+	if errors.As(err, &c) {
+		return c
+	}
+	return nil
+}
+
+// IsTransient reports whether err is a temporary failure that's worth
+// retrying, ie. throttling or a provider outage.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrThrottled) || errors.Is(err, ErrProviderOutage) {
+		return true
+	}
+
+	// Unclassified network errors.
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,87 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/twilio/twilio-go/client"
+)
+
+func TestClassifyAWSError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want *ErrorClass
+	}{
+		{awserr.New("ThrottlingException", "rate exceeded", nil), ErrThrottled},
+		{awserr.New("MessageRejected", "email address not verified", nil), ErrContentRejected},
+		{awserr.New("UnrecognizedClientException", "invalid token", nil), ErrAuth},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "boom", nil), 502, "req"), ErrProviderOutage},
+		{awserr.NewRequestFailure(awserr.New("BadRequestException", "bad", nil), 400, "req"), nil},
+	} {
+		err := classifyAWSError(c.err)
+		if got := Class(err); got != c.want {
+			t.Errorf("%v: got class %v, want %v", c.err, got, c.want)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%v: original error not preserved", c.err)
+		}
+	}
+}
+
+func TestClassifyAWSContextError(t *testing.T) {
+	// A cancelled request isn't the provider's doing, while a deadline means
+	// it took too long to respond.
+	err := classifyAWSError(awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled))
+	if Class(err) != nil || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation to be unclassified, got %v (%v)", err, Class(err))
+	}
+
+	err = classifyAWSError(awserr.New(request.CanceledErrorCode, "request context canceled", context.DeadlineExceeded))
+	if Class(err) != ErrProviderOutage {
+		t.Errorf("expected a deadline to be a provider outage, got %v", Class(err))
+	}
+}
+
+func TestClassifySNSError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want *ErrorClass
+	}{
+		{awserr.New("InvalidParameter", "Invalid parameter: PhoneNumber Reason: +1 is not valid to publish to", nil), ErrInvalidRecipient},
+		{awserr.New("InvalidParameter", "Invalid parameter: TargetArn Reason: No endpoint found", nil), ErrInvalidRecipient},
+		{awserr.New("InvalidParameter", "Invalid parameter: MessageAttributes Reason: invalid sender ID", nil), nil},
+		{awserr.New("ParameterValueInvalid", "Invalid parameter value: AWS.SNS.SMS.MaxPrice", nil), nil},
+		{awserr.New("AuthorizationError", "not authorized", nil), ErrAuth},
+		{awserr.New("Throttling", "rate exceeded", nil), ErrThrottled},
+	} {
+		if got := Class(classifySNSError(c.err)); got != c.want {
+			t.Errorf("%v: got class %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestClassifyTwilioError(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		want      *ErrorClass
+		transient bool
+	}{
+		{&client.TwilioRestError{Status: 400, Code: 21211}, ErrInvalidRecipient, false},
+		{&client.TwilioRestError{Status: 400, Code: 21617}, ErrContentRejected, false},
+		{&client.TwilioRestError{Status: 401, Code: 20003}, ErrAuth, false},
+		{&client.TwilioRestError{Status: 429, Code: 20429}, ErrThrottled, true},
+		{&client.TwilioRestError{Status: 503}, ErrProviderOutage, true},
+		{errors.New("unknown"), nil, false},
+	} {
+		err := classifyTwilioError(c.err)
+		if got := Class(err); got != c.want {
+			t.Errorf("%v: got class %v, want %v", c.err, got, c.want)
+		}
+		if IsTransient(err) != c.transient {
+			t.Errorf("%v: expected transient=%v", c.err, c.transient)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/francoispqt/onelog"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// Member is a named messenger that's part of a virtual messenger.
+type Member struct {
+	Name      string
+	Messenger Messenger
+}
+
+// BreakerOpt configures the circuit breaker of each failover member.
+type BreakerOpt struct {
+	// Failures is the number of consecutive transient failures after which a
+	// member is skipped.
+	Failures int
+	// Cooldown is how long a member is skipped for before it's tried again.
+	Cooldown time.Duration
+}
+
+// failover is a messenger that pushes through the first of its members that
+// succeeds, falling through to the next on transient errors.
+type failover struct {
+	name     string
+	members  []Member
+	breakers []*breaker
+
+	logger *onelog.Logger
+}
+
+// NewFailover returns a messenger that tries members in order. A member is
+// skipped for the cooldown after failing opt.Failures times in a row.
+func NewFailover(name string, members []Member, opt BreakerOpt, l *onelog.Logger) (Messenger, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no messengers configured")
+	}
+	if opt.Failures < 1 {
+		opt.Failures = defaultBreakerFailures
+	}
+	if opt.Cooldown <= 0 {
+		opt.Cooldown = defaultBreakerCooldown
+	}
+
+	f := &failover{name: name, members: members, logger: l}
+	for range members {
+		f.breakers = append(f.breakers, &breaker{max: opt.Failures, cooldown: opt.Cooldown})
+	}
+	return f, nil
+}
+
+func (f *failover) Name() string {
+	return "failover"
+}
+
+// Push sends the message through the first available member that succeeds.
+func (f *failover) Push(msg Message) error {
+	return f.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the message through the first available member that
+// succeeds, bound to ctx.
+func (f *failover) PushContext(ctx context.Context, msg Message) error {
+	var lastErr error
+	for i, m := range f.members {
+		b := f.breakers[i]
+		if !b.allow(time.Now()) {
+			continue
+		}
+
+		err := PushContext(ctx, m.Messenger, msg)
+		if err == nil || !IsTransient(err) {
+			// Permanent errors are about the message, not the member.
+			b.success()
+			return err
+		}
+		if ctx.Err() != nil {
+			b.release()
+			return err
+		}
+
+		if b.failure(time.Now()) {
+			f.logger.WarnWith("skipping messenger after consecutive failures").String("failover", f.name).String("messenger", m.Name).Err("err", err).Write()
+		}
+		if i < len(f.members)-1 {
+			f.logger.InfoWith("failing over to next messenger").String("failover", f.name).String("messenger", m.Name).Err("err", err).Write()
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return NewError(ErrProviderOutage, errors.New("all messengers are unavailable"))
+	}
+	return lastErr
+}
+
+// Flush and Close are no-ops as the members are flushed and closed on their
+// own.
+func (f *failover) Flush() error {
+	return nil
+}
+
+func (f *failover) Close() error {
+	return nil
+}
+
+// breaker is a circuit breaker that opens after max consecutive failures.
+// Once the cooldown is over, a single trial push is let through, which closes
+// it on success and reopens it on failure.
+type breaker struct {
+	max      int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// allow reports whether a push may go through.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.max {
+		return true
+	}
+	if now.Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.trial = false
+	b.mu.Unlock()
+}
+
+// release ends a push that neither succeeded nor failed.
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.trial = false
+	b.mu.Unlock()
+}
+
+// failure records a failed push and reports whether it opened the breaker.
+func (b *breaker) failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.failures < b.max {
+		return false
+	}
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
@@ -0,0 +1,77 @@
+package messenger
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/francoispqt/onelog"
+)
+
+// funcMessenger pushes with fn and counts the pushes.
+type funcMessenger struct {
+	fn    func() error
+	count *int
+}
+
+func (f funcMessenger) Name() string { return "func" }
+func (f funcMessenger) Push(Message) error {
+	*f.count++
+	return f.fn()
+}
+func (f funcMessenger) Flush() error { return nil }
+func (f funcMessenger) Close() error { return nil }
+
+func TestFailover(t *testing.T) {
+	var (
+		primaryErr      error
+		primary, backup int
+	)
+	m, err := NewFailover("sms", []Member{
+		{Name: "twilio", Messenger: funcMessenger{fn: func() error { return primaryErr }, count: &primary}},
+		{Name: "pinpoint", Messenger: funcMessenger{fn: func() error { return nil }, count: &backup}},
+	}, BreakerOpt{Failures: 2, Cooldown: 50 * time.Millisecond}, onelog.New(os.Stderr, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Permanent errors don't fall through.
+	primaryErr = NewError(ErrInvalidRecipient, errors.New("bad number"))
+	if err := m.Push(Message{}); Class(err) != ErrInvalidRecipient || backup != 0 {
+		t.Fatalf("expected invalid recipient from the primary, got %v (backup pushes %d)", err, backup)
+	}
+
+	// Transient errors do, until the breaker opens and the primary is skipped.
+	primaryErr = NewError(ErrProviderOutage, errors.New("down"))
+	primary = 0
+	for i := 0; i < 3; i++ {
+		if err := m.Push(Message{}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if primary != 2 || backup != 3 {
+		t.Fatalf("expected 2 primary and 3 backup pushes, got %d and %d", primary, backup)
+	}
+
+	// After the cooldown, a trial push closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	primaryErr = nil
+	if err := m.Push(Message{}); err != nil || primary != 3 {
+		t.Fatalf("expected a trial push to the primary, got %v (%d pushes)", err, primary)
+	}
+}
+
+func TestFailoverAllUnavailable(t *testing.T) {
+	var n int
+	m, _ := NewFailover("sms", []Member{
+		{Name: "twilio", Messenger: funcMessenger{fn: func() error { return NewError(ErrThrottled, errors.New("slow down")) }, count: &n}},
+	}, BreakerOpt{Failures: 1, Cooldown: time.Minute}, onelog.New(os.Stderr, 0))
+
+	if err := m.Push(Message{}); Class(err) != ErrThrottled {
+		t.Fatalf("expected the member's error, got %v", err)
+	}
+	if err := m.Push(Message{}); Class(err) != ErrProviderOutage || n != 1 {
+		t.Fatalf("expected an outage with the member skipped, got %v (%d pushes)", err, n)
+	}
+}
@@ -0,0 +1,160 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Fan-out policies.
+const (
+	// FanoutAll fails the push if any of the channels fails.
+	FanoutAll = "all"
+	// FanoutAny fails the push only if all the channels fail.
+	FanoutAny = "any"
+)
+
+// ChannelResult is the outcome of pushing a message through one of the
+// channels of a fan-out.
+type ChannelResult struct {
+	Messenger string
+	Err       error
+}
+
+type channelsKey struct{}
+
+// WithChannelResults returns a context that collects the channel results of
+// fan-out pushes made with it, and a func returning them. Retried pushes with
+// the same context skip the channels that already succeeded.
+func WithChannelResults(ctx context.Context) (context.Context, func() []ChannelResult) {
+	var (
+		mu  sync.Mutex
+		res []ChannelResult
+	)
+	set := func(r []ChannelResult) {
+		mu.Lock()
+		res = r
+		mu.Unlock()
+	}
+	get := func() []ChannelResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return res
+	}
+
+	return context.WithValue(ctx, channelsKey{}, channelResults{set: set, get: get}), get
+}
+
+type channelResults struct {
+	set func([]ChannelResult)
+	get func() []ChannelResult
+}
+
+// fanout is a messenger that pushes every message through all of its members
+// in parallel.
+type fanout struct {
+	name    string
+	members []Member
+	policy  string
+}
+
+// NewFanout returns a messenger that pushes messages through all members at
+// once, succeeding as per policy.
+func NewFanout(name string, members []Member, policy string) (Messenger, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no messengers configured")
+	}
+	switch policy {
+	case "":
+		policy = FanoutAll
+	case FanoutAll, FanoutAny:
+	default:
+		return nil, fmt.Errorf("invalid policy: %s", policy)
+	}
+
+	return &fanout{name: name, members: members, policy: policy}, nil
+}
+
+func (f *fanout) Name() string {
+	return "fanout"
+}
+
+// Push sends the message through all the channels.
+func (f *fanout) Push(msg Message) error {
+	return f.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the message through all the channels, bound to ctx.
+func (f *fanout) PushContext(ctx context.Context, msg Message) error {
+	var (
+		rec, _ = ctx.Value(channelsKey{}).(channelResults)
+		prev   map[string]bool
+	)
+	if rec.get != nil {
+		prev = make(map[string]bool)
+		for _, r := range rec.get() {
+			if r.Err == nil {
+				prev[r.Messenger] = true
+			}
+		}
+	}
+
+	// Channels push under the caller's deadline, but not with its results,
+	// which a nested fan-out would overwrite with its own. Pushes still running
+	// when the fan-out returns, eg. of messengers that don't take a context,
+	// are cancelled.
+	cctx, cancel := context.WithCancel(context.WithValue(ctx, channelsKey{}, channelResults{}))
+	defer cancel()
+
+	var (
+		res = make([]ChannelResult, len(f.members))
+		wg  sync.WaitGroup
+	)
+	for i, m := range f.members {
+		res[i].Messenger = m.Name
+		if prev[m.Name] {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, m Member) {
+			defer wg.Done()
+			res[i].Err = PushContext(cctx, m.Messenger, msg)
+		}(i, m)
+	}
+	wg.Wait()
+
+	if rec.set != nil {
+		rec.set(res)
+	}
+
+	// Prefer reporting a transient error so that the push is retried.
+	var (
+		failed int
+		err    error
+	)
+	for _, r := range res {
+		if r.Err == nil {
+			continue
+		}
+		failed++
+		if err == nil || (IsTransient(r.Err) && !IsTransient(err)) {
+			err = fmt.Errorf("%s: %w", r.Messenger, r.Err)
+		}
+	}
+
+	if failed == 0 || (f.policy == FanoutAny && failed < len(res)) {
+		return nil
+	}
+	return err
+}
+
+// Flush and Close are no-ops as the members are flushed and closed on their
+// own.
+func (f *fanout) Flush() error {
+	return nil
+}
+
+func (f *fanout) Close() error {
+	return nil
+}
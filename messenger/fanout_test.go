@@ -0,0 +1,94 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFanout(t *testing.T) {
+	var (
+		emailErr, smsErr error
+		emails, smses    int
+	)
+	members := []Member{
+		{Name: "ses", Messenger: funcMessenger{fn: func() error { return emailErr }, count: &emails}},
+		{Name: "twilio", Messenger: funcMessenger{fn: func() error { return smsErr }, count: &smses}},
+	}
+
+	all, err := NewFanout("alerts", members, FanoutAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyOf, _ := NewFanout("alerts", members, FanoutAny)
+
+	smsErr = NewError(ErrInvalidRecipient, errors.New("no phone"))
+	if err := all.Push(Message{}); Class(err) != ErrInvalidRecipient {
+		t.Errorf("all: expected the failed channel's error, got %v", err)
+	}
+	if err := anyOf.Push(Message{}); err != nil {
+		t.Errorf("any: expected success, got %v", err)
+	}
+	if emails != 2 || smses != 2 {
+		t.Fatalf("expected 2 pushes per channel, got %d and %d", emails, smses)
+	}
+
+	// Retries with the same context only push the failed channels.
+	smsErr = NewError(ErrProviderOutage, errors.New("down"))
+	ctx, results := WithChannelResults(context.Background())
+	if err := all.(ContextMessenger).PushContext(ctx, Message{}); !IsTransient(err) {
+		t.Fatalf("expected a transient error, got %v", err)
+	}
+	smsErr = nil
+	if err := all.(ContextMessenger).PushContext(ctx, Message{}); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if emails != 3 || smses != 4 {
+		t.Fatalf("expected the retry to skip the sent channel, got %d and %d pushes", emails, smses)
+	}
+	if r := results(); len(r) != 2 || r[0].Messenger != "ses" || r[0].Err != nil || r[1].Err != nil {
+		t.Fatalf("unexpected results: %+v", r)
+	}
+
+	if _, err := NewFanout("alerts", members, "most"); err == nil {
+		t.Error("expected error for an invalid policy")
+	}
+}
+
+// ctxFuncMessenger pushes with fn, passing it the push's context.
+type ctxFuncMessenger struct {
+	fn func(context.Context) error
+}
+
+func (c ctxFuncMessenger) Name() string                                     { return "ctxfunc" }
+func (c ctxFuncMessenger) Push(msg Message) error                           { return c.PushContext(context.Background(), msg) }
+func (c ctxFuncMessenger) PushContext(ctx context.Context, _ Message) error { return c.fn(ctx) }
+func (c ctxFuncMessenger) Flush() error                                     { return nil }
+func (c ctxFuncMessenger) Close() error                                     { return nil }
+
+func TestFanoutContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	// The channels, including those of a nested fan-out, keep the caller's
+	// deadline.
+	checkDeadline := ctxFuncMessenger{fn: func(c context.Context) error {
+		if d, ok := c.Deadline(); !ok || !d.Equal(deadline) {
+			t.Errorf("expected the caller's deadline %v, got %v", deadline, d)
+		}
+		return nil
+	}}
+
+	inner, _ := NewFanout("inner", []Member{{Name: "a", Messenger: checkDeadline}, {Name: "b", Messenger: checkDeadline}}, FanoutAll)
+	outer, _ := NewFanout("outer", []Member{{Name: "inner", Messenger: inner}, {Name: "c", Messenger: checkDeadline}}, FanoutAll)
+
+	ctx, results := WithChannelResults(ctx)
+	if err := outer.(ContextMessenger).PushContext(ctx, Message{}); err != nil {
+		t.Fatalf("PushContext: %v", err)
+	}
+	if r := results(); len(r) != 2 || r[0].Messenger != "inner" || r[1].Messenger != "c" {
+		t.Fatalf("unexpected results: %+v", r)
+	}
+}
@@ -0,0 +1,134 @@
+// Package phone normalises phone numbers to the E.164 format,
+// +<country code><subscriber number>, as expected by SMS providers.
+package phone
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// E.164 numbers have at most 15 digits, including the country code. The
+	// shortest ones in use have 7.
+	minDigits = 7
+	maxDigits = 15
+
+	// maxNationalDigits is the length of national numbers past which a number
+	// starting with the default country code is taken to include it.
+	maxNationalDigits = 10
+)
+
+// Error is returned for a phone number that can't be normalised.
+type Error struct {
+	Number string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid phone number %q: %s", e.Number, e.Reason)
+}
+
+// Normalize returns the phone number v, a string or a number as decoded from
+// JSON, in E.164 format. Spaces, dashes, dots, slashes and parentheses are
+// ignored, and a leading 00 is read as +.
+//
+// Numbers without a country code get defaultCC, eg. "91" or "+91", after
+// dropping the national trunk prefix 0. Those starting with defaultCC that are
+// longer than national numbers (10 digits) are taken to include it already,
+// which is how numbers stored as JSON numbers usually look. Without defaultCC,
+// numbers need a country code.
+func Normalize(v interface{}, defaultCC string) (string, error) {
+	num, err := toString(v)
+	if err != nil {
+		return "", err
+	}
+
+	cc, err := CountryCode(defaultCC)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		digits strings.Builder
+		plus   bool
+	)
+	for i, r := range strings.TrimSpace(num) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			plus = true
+		case unicode.IsSpace(r) || strings.ContainsRune("-./()", r):
+		default:
+			return "", &Error{Number: num, Reason: fmt.Sprintf("invalid character %q", r)}
+		}
+	}
+
+	d := digits.String()
+	if d == "" {
+		return "", &Error{Number: num, Reason: "no digits"}
+	}
+	if !plus && strings.HasPrefix(d, "00") {
+		d, plus = d[2:], true
+	}
+
+	if !plus {
+		switch {
+		case cc == "":
+			return "", &Error{Number: num, Reason: "missing country code"}
+		case strings.HasPrefix(d, cc) && len(d) > maxNationalDigits:
+		default:
+			d = cc + strings.TrimPrefix(d, "0")
+		}
+	}
+
+	if len(d) < minDigits || len(d) > maxDigits {
+		return "", &Error{Number: num, Reason: fmt.Sprintf("should have %d to %d digits", minDigits, maxDigits)}
+	}
+	if d[0] == '0' {
+		return "", &Error{Number: num, Reason: "invalid country code"}
+	}
+
+	return "+" + d, nil
+}
+
+// CountryCode validates a calling country code, with or without the +, and
+// returns its digits.
+func CountryCode(s string) (string, error) {
+	cc := strings.TrimPrefix(strings.TrimSpace(s), "+")
+	if cc == "" {
+		return "", nil
+	}
+	if len(cc) > 3 || cc[0] == '0' || strings.TrimFunc(cc, func(r rune) bool { return r >= '0' && r <= '9' }) != "" {
+		return "", fmt.Errorf("invalid country code: %s", s)
+	}
+	return cc, nil
+}
+
+// toString returns a phone number given as a string or a number.
+func toString(v interface{}) (string, error) {
+	switch n := v.(type) {
+	case string:
+		return n, nil
+	case json.Number:
+		return n.String(), nil
+	case float64:
+		if n < 0 || n != math.Trunc(n) || n > 1e15 {
+			return "", &Error{Number: fmt.Sprint(n), Reason: "not a whole number"}
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(n), nil
+	case int64:
+		return strconv.FormatInt(n, 10), nil
+	case uint64:
+		return strconv.FormatUint(n, 10), nil
+	case nil:
+		return "", &Error{Reason: "missing"}
+	}
+	return "", &Error{Number: fmt.Sprint(v), Reason: fmt.Sprintf("unsupported type %T", v)}
+}
@@ -0,0 +1,65 @@
+package phone
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, c := range []struct {
+		in   interface{}
+		cc   string
+		want string
+	}{
+		{"+91 98450 12345", "", "+919845012345"},
+		{"+1 (415) 555-0123", "", "+14155550123"},
+		{"0044 7911 123456", "", "+447911123456"},
+		{"98450 12345", "91", "+919845012345"},
+		{"07911 123456", "+44", "+447911123456"},
+		{"919845012345", "91", "+919845012345"},
+		{"9145012345", "91", "+919145012345"},
+		{float64(919845012345), "91", "+919845012345"},
+		{float64(9845012345), "91", "+919845012345"},
+		{json.Number("14155550123"), "1", "+14155550123"},
+		{int64(14155550123), "1", "+14155550123"},
+	} {
+		got, err := Normalize(c.in, c.cc)
+		if err != nil {
+			t.Errorf("%v (%s): %v", c.in, c.cc, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%v (%s): got %s, want %s", c.in, c.cc, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	for _, c := range []struct {
+		in interface{}
+		cc string
+	}{
+		{"98450 12345", ""},
+		{"+91 98450 abc", ""},
+		{"+12345", ""},
+		{"+1234567890123456", ""},
+		{"", "91"},
+		{nil, "91"},
+		{9.5, "91"},
+		{true, "91"},
+		{"+0123456789", ""},
+	} {
+		_, err := Normalize(c.in, c.cc)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%v (%s): expected *Error, got %v", c.in, c.cc, err)
+		}
+	}
+
+	for _, cc := range []string{"0", "1234", "+9a"} {
+		if _, err := CountryCode(cc); err == nil {
+			t.Errorf("expected error for country code %s", cc)
+		}
+	}
+}
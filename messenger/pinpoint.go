@@ -1,19 +1,26 @@
 package messenger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/pinpoint"
 	"github.com/francoispqt/onelog"
 )
 
+func init() {
+	Register("pinpoint", NewPinpoint)
+}
+
 var (
 	channelType = "SMS"
 )
 
 type pinpointCfg struct {
 	awsCfg
+	smsCfg
 	AppID       string `json:"app_id"`
 	MessageType string `json:"message_type"`
 	SenderID    string `json:"sender_id"`
@@ -33,9 +40,14 @@ func (p pinpointMessenger) Name() string {
 
 // Push sends the sms through pinpoint API.
 func (p pinpointMessenger) Push(msg Message) error {
-	phone, ok := msg.Subscriber.Attribs["phone"].(string)
-	if !ok {
-		return fmt.Errorf("could not find subscriber phone")
+	return p.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the sms through pinpoint API, bound to ctx.
+func (p pinpointMessenger) PushContext(ctx context.Context, msg Message) error {
+	phone, err := p.cfg.phone(msg)
+	if err != nil {
+		return err
 	}
 
 	body := string(msg.Body)
@@ -51,19 +63,23 @@ func (p pinpointMessenger) Push(msg Message) error {
 				SMSMessage: &pinpoint.SMSMessage{
 					Body:        &body,
 					MessageType: &p.cfg.MessageType,
-					SenderId:    &p.cfg.SenderID,
+					SenderId:    aws.String(p.cfg.senderID(msg, p.cfg.SenderID)),
 				},
 			},
 		},
 	}
 
-	out, err := p.client.SendMessages(payload)
+	out, err := p.client.SendMessagesWithContext(ctx, payload)
 	if err != nil {
-		return err
+		return classifyAWSError(err)
 	}
 
-	if p.cfg.Log {
-		for phone, result := range out.MessageResponse.Result {
+	// The request can succeed while delivery to the address fails.
+	for phone, result := range out.MessageResponse.Result {
+		if err := pinpointResultError(result); err != nil {
+			return err
+		}
+		if p.cfg.Log {
 			p.logger.InfoWith("successfully sent sms").String("phone", phone).String("result", fmt.Sprintf("%#+v", result)).Write()
 		}
 	}
@@ -71,6 +87,28 @@ func (p pinpointMessenger) Push(msg Message) error {
 	return nil
 }
 
+// pinpointResultError returns the classified error of a failed per-address
+// delivery result, if any.
+func pinpointResultError(r *pinpoint.MessageResult) error {
+	if r == nil || r.DeliveryStatus == nil {
+		return nil
+	}
+
+	var class *ErrorClass
+	switch *r.DeliveryStatus {
+	case pinpoint.DeliveryStatusSuccessful, pinpoint.DeliveryStatusDuplicate:
+		return nil
+	case pinpoint.DeliveryStatusThrottled:
+		class = ErrThrottled
+	case pinpoint.DeliveryStatusPermanentFailure, pinpoint.DeliveryStatusOptOut:
+		class = ErrInvalidRecipient
+	default:
+		class = ErrProviderOutage
+	}
+
+	return NewError(class, fmt.Errorf("delivery %s: %s", aws.StringValue(r.DeliveryStatus), aws.StringValue(r.StatusMessage)))
+}
+
 func (p pinpointMessenger) Flush() error {
 	return nil
 }
@@ -85,6 +123,9 @@ func NewPinpoint(cfg []byte, l *onelog.Logger) (Messenger, error) {
 	if err := json.Unmarshal(cfg, &c); err != nil {
 		return nil, err
 	}
+	if err := c.smsCfg.validate(); err != nil {
+		return nil, err
+	}
 
 	if c.AppID == "" {
 		return nil, fmt.Errorf("invalid app_id")
@@ -0,0 +1,60 @@
+package messenger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/francoispqt/onelog"
+)
+
+// Constructor creates a Messenger from its JSON config.
+type Constructor func(cfg []byte, l *onelog.Logger) (Messenger, error)
+
+var (
+	regMu    sync.RWMutex
+	registry = map[string]Constructor{}
+)
+
+// Register makes a messenger backend available under the given type name. It
+// is meant to be called from the init() of the package implementing the
+// backend, so that importing the package is enough to compile it in. It
+// panics if the name is registered twice.
+func Register(name string, c Constructor) {
+	regMu.Lock()
+	defer regMu.Unlock()
+
+	if c == nil {
+		panic("messenger: nil constructor for " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("messenger: " + name + " registered twice")
+	}
+	registry[name] = c
+}
+
+// New creates a messenger of a registered type.
+func New(typ string, cfg []byte, l *onelog.Logger) (Messenger, error) {
+	regMu.RLock()
+	c, ok := registry[typ]
+	regMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown messenger type %q (available: %s)", typ, strings.Join(Types(), ", "))
+	}
+	return c(cfg, l)
+}
+
+// Types returns the sorted names of the registered messenger types.
+func Types() []string {
+	regMu.RLock()
+	defer regMu.RUnlock()
+
+	out := make([]string, 0, len(registry))
+	for name := range registry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
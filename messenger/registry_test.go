@@ -0,0 +1,34 @@
+package messenger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+)
+
+func TestRegistry(t *testing.T) {
+	types := strings.Join(Types(), ",")
+	for _, name := range []string{"pinpoint", "ses", "twilio"} {
+		if !strings.Contains(types, name) {
+			t.Errorf("%s not registered: %s", name, types)
+		}
+	}
+
+	Register("test-registry", func(cfg []byte, l *onelog.Logger) (Messenger, error) {
+		return nil, nil
+	})
+	if _, err := New("test-registry", nil, nil); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := New("does-not-exist", nil, nil); err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on duplicate registration")
+		}
+	}()
+	Register("ses", NewAWSSES)
+}
@@ -1,27 +1,66 @@
 package messenger
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sesv2"
 	"github.com/francoispqt/onelog"
-	"github.com/knadh/smtppool"
 )
 
+func init() {
+	Register("ses", NewAWSSES)
+}
+
 const (
 	ContentTypeHTML  = "html"
 	ContentTypePlain = "plain"
 )
 
+const (
+	sesAPIv1 = "v1"
+	sesAPIv2 = "v2"
+
+	// sesMaxTags is the max number of tags SES accepts on a message.
+	sesMaxTags = 50
+
+	defaultSESConfigSetTagPrefix = "ses-configuration-set:"
+)
+
+// sesTagReplacer matches characters that aren't allowed in SES tag names and
+// values.
+var sesTagReplacer = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
 type sesCfg struct {
 	awsCfg
+	// APIVersion is "v1" (SendRawEmail, default) or "v2" (SESv2 SendEmail).
+	// The options below are only supported by v2.
+	APIVersion string `json:"api_version"`
+
+	// ConfigurationSet is the default configuration set of messages.
+	ConfigurationSet string `json:"configuration_set"`
+	// ConfigurationSetTagPrefix marks a campaign tag that overrides the
+	// configuration set, eg. the tag "ses-configuration-set:marketing" sends
+	// the campaign with the "marketing" configuration set.
+	ConfigurationSetTagPrefix string `json:"configuration_set_tag_prefix"`
+
+	// ContactList and Topic set the list management options, which let SES
+	// handle unsubscribes for the contact list.
+	ContactList string `json:"contact_list"`
+	Topic       string `json:"topic"`
+
 	Log bool `json:"log"`
 }
 
 type sesMessenger struct {
-	cfg    sesCfg
-	client *ses.SES
+	cfg      sesCfg
+	client   *ses.SES
+	clientV2 *sesv2.SESV2
 
 	logger *onelog.Logger
 }
@@ -30,49 +69,23 @@ func (s sesMessenger) Name() string {
 	return "ses"
 }
 
-// Push sends the sms through pinpoint API.
+// Push sends the email through SES API.
 func (s sesMessenger) Push(msg Message) error {
-	// convert attachments to smtppool.Attachments
-	var files []smtppool.Attachment
-	if msg.Attachments != nil {
-		files = make([]smtppool.Attachment, 0, len(msg.Attachments))
-		for _, f := range msg.Attachments {
-			a := smtppool.Attachment{
-				Filename: f.Name,
-				Header:   f.Header,
-				Content:  make([]byte, len(f.Content)),
-			}
-			copy(a.Content, f.Content)
-			files = append(files, a)
-		}
-	}
-
-	fromEmail := msg.From
-	if msg.Campaign != nil {
-		fromEmail = msg.Campaign.FromEmail
-	}
-
-	email := smtppool.Email{
-		From:        fromEmail,
-		To:          []string{msg.Subscriber.Email},
-		Subject:     msg.Subject,
-		Sender:      msg.From,
-		Headers:     msg.Headers,
-		Attachments: files,
-	}
-
-	switch {
-	case msg.ContentType == ContentTypePlain:
-		email.Text = msg.Body
-	default:
-		email.HTML = msg.Body
-	}
+	return s.PushContext(context.Background(), msg)
+}
 
+// PushContext sends the email through SES API, bound to ctx.
+func (s sesMessenger) PushContext(ctx context.Context, msg Message) error {
+	email := newEmail(msg)
 	emailB, err := email.Bytes()
 	if err != nil {
 		return err
 	}
 
+	if s.clientV2 != nil {
+		return s.pushV2(ctx, msg, email.From, emailB)
+	}
+
 	input := &ses.SendRawEmailInput{
 		Source:       &email.From,
 		Destinations: []*string{&msg.Subscriber.Email},
@@ -81,9 +94,9 @@ func (s sesMessenger) Push(msg Message) error {
 		},
 	}
 
-	out, err := s.client.SendRawEmail(input)
+	out, err := s.client.SendRawEmailWithContext(ctx, input)
 	if err != nil {
-		return err
+		return classifyAWSError(err)
 	}
 
 	if s.cfg.Log {
@@ -93,6 +106,92 @@ func (s sesMessenger) Push(msg Message) error {
 	return nil
 }
 
+// pushV2 sends the raw email through SESv2 API with the configuration set,
+// tags and list management options.
+func (s sesMessenger) pushV2(ctx context.Context, msg Message, from string, raw []byte) error {
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(from),
+		Destination: &sesv2.Destination{
+			ToAddresses: []*string{aws.String(msg.Subscriber.Email)},
+		},
+		Content: &sesv2.EmailContent{
+			Raw: &sesv2.RawMessage{Data: raw},
+		},
+		EmailTags: sesTags(msg),
+	}
+	if cs := s.configurationSet(msg); cs != "" {
+		input.ConfigurationSetName = aws.String(cs)
+	}
+	if s.cfg.ContactList != "" {
+		input.ListManagementOptions = &sesv2.ListManagementOptions{
+			ContactListName: aws.String(s.cfg.ContactList),
+		}
+		if s.cfg.Topic != "" {
+			input.ListManagementOptions.TopicName = aws.String(s.cfg.Topic)
+		}
+	}
+
+	out, err := s.clientV2.SendEmailWithContext(ctx, input)
+	if err != nil {
+		return classifyAWSError(err)
+	}
+
+	if s.cfg.Log {
+		s.logger.InfoWith("successfully sent email").String("email", msg.Subscriber.Email).String("message_id", aws.StringValue(out.MessageId)).Write()
+	}
+
+	return nil
+}
+
+// configurationSet returns the configuration set of the message, which is the
+// one named by the campaign's prefixed tag, if any, or the default.
+func (s sesMessenger) configurationSet(msg Message) string {
+	if msg.Campaign != nil {
+		for _, t := range msg.Campaign.Tags {
+			if strings.HasPrefix(t, s.cfg.ConfigurationSetTagPrefix) {
+				return strings.TrimPrefix(t, s.cfg.ConfigurationSetTagPrefix)
+			}
+		}
+	}
+	return s.cfg.ConfigurationSet
+}
+
+// sesTags returns the message tags identifying the subscriber and the
+// campaign. Each campaign tag is added as "tag_<tag>" with the value "true".
+// Tags with empty values and repeated names, which SES rejects, are skipped.
+func sesTags(msg Message) []*sesv2.MessageTag {
+	var (
+		tags []*sesv2.MessageTag
+		seen = make(map[string]bool)
+	)
+	add := func(name, value string) {
+		name = sesTagReplacer.ReplaceAllString(name, "_")
+		if value == "" || seen[name] || len(tags) >= sesMaxTags {
+			return
+		}
+		seen[name] = true
+		tags = append(tags, &sesv2.MessageTag{
+			Name:  aws.String(name),
+			Value: aws.String(sesTagReplacer.ReplaceAllString(value, "_")),
+		})
+	}
+
+	add("subscriber_uuid", msg.Subscriber.UUID)
+	if msg.Campaign == nil {
+		return tags
+	}
+
+	add("campaign_uuid", msg.Campaign.UUID)
+	add("campaign_name", msg.Campaign.Name)
+	for _, t := range msg.Campaign.Tags {
+		if t != "" {
+			add("tag_"+t, "true")
+		}
+	}
+
+	return tags
+}
+
 func (s sesMessenger) Flush() error {
 	return nil
 }
@@ -101,13 +200,29 @@ func (s sesMessenger) Close() error {
 	return nil
 }
 
-// NewAWSSES creates new instance of pinpoint
+// NewAWSSES creates new instance of ses
 func NewAWSSES(cfg []byte, l *onelog.Logger) (Messenger, error) {
 	var c sesCfg
 	if err := json.Unmarshal(cfg, &c); err != nil {
 		return nil, err
 	}
 
+	switch c.APIVersion {
+	case "", sesAPIv1:
+		if c.ConfigurationSet != "" || c.ContactList != "" {
+			return nil, fmt.Errorf("configuration_set and contact_list require api_version v2")
+		}
+	case sesAPIv2:
+		if c.Topic != "" && c.ContactList == "" {
+			return nil, fmt.Errorf("topic requires contact_list")
+		}
+		if c.ConfigurationSetTagPrefix == "" {
+			c.ConfigurationSetTagPrefix = defaultSESConfigSetTagPrefix
+		}
+	default:
+		return nil, fmt.Errorf("invalid api_version: %s", c.APIVersion)
+	}
+
 	sess, err := newAWSSession(c.awsCfg)
 	if err != nil {
 		return nil, err
@@ -116,10 +231,15 @@ func NewAWSSES(cfg []byte, l *onelog.Logger) (Messenger, error) {
 		return nil, err
 	}
 
-	svc := ses.New(sess)
-	return sesMessenger{
-		client: svc,
+	m := sesMessenger{
 		cfg:    c,
 		logger: l,
-	}, nil
+	}
+	if c.APIVersion == sesAPIv2 {
+		m.clientV2 = sesv2.New(sess)
+	} else {
+		m.client = ses.New(sess)
+	}
+
+	return m, nil
 }
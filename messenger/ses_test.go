@@ -0,0 +1,110 @@
+package messenger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sesv2"
+	"github.com/knadh/listmonk/models"
+)
+
+func TestSESPushV2(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"MessageId": "m1"}`))
+	}))
+	defer srv.Close()
+
+	sess, err := newAWSSession(awsCfg{AccessKey: "test", SecretKey: "test", Region: "us-east-1", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("newAWSSession: %v", err)
+	}
+	m := sesMessenger{
+		cfg: sesCfg{
+			ConfigurationSet:          "default",
+			ConfigurationSetTagPrefix: defaultSESConfigSetTagPrefix,
+			ContactList:               "newsletter",
+			Topic:                     "weekly",
+		},
+		clientV2: sesv2.New(sess),
+	}
+
+	msg := Message{
+		From:        "listmonk <noreply@example.com>",
+		Subject:     "Hello",
+		ContentType: ContentTypePlain,
+		Body:        []byte("Hello there"),
+		Subscriber:  models.Subscriber{UUID: "sub-1", Email: "user@example.com"},
+		Campaign: &models.Campaign{
+			UUID:      "camp-1",
+			FromEmail: "listmonk <noreply@example.com>",
+			Name:      "Weekly digest #1",
+			Tags:      []string{"ses-configuration-set:marketing", "news"},
+		},
+	}
+	if err := m.Push(msg); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	for _, want := range []string{
+		`"ConfigurationSetName":"marketing"`,
+		`"ContactListName":"newsletter"`,
+		`"TopicName":"weekly"`,
+		`"ToAddresses":["user@example.com"]`,
+		`{"Name":"subscriber_uuid","Value":"sub-1"}`,
+		`{"Name":"campaign_name","Value":"Weekly_digest_1"}`,
+		`{"Name":"tag_news","Value":"true"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("request body missing %s: %s", want, body)
+		}
+	}
+
+	// Without the override tag, the default configuration set is used.
+	msg.Campaign.Tags = nil
+	if got := m.configurationSet(msg); got != "default" {
+		t.Errorf("expected default configuration set, got %s", got)
+	}
+}
+
+func TestSESTagsLimit(t *testing.T) {
+	msg := Message{Campaign: &models.Campaign{UUID: "c"}}
+	for i := 0; i < 100; i++ {
+		msg.Campaign.Tags = append(msg.Campaign.Tags, fmt.Sprintf("t%d", i))
+	}
+	if n := len(sesTags(msg)); n != sesMaxTags {
+		t.Fatalf("expected %d tags, got %d", sesMaxTags, n)
+	}
+}
+
+func TestSESTagsDedupe(t *testing.T) {
+	msg := Message{Campaign: &models.Campaign{UUID: "c", Tags: []string{"news", "", "a b", "a_b", "news"}}}
+
+	var got []string
+	for _, tag := range sesTags(msg) {
+		got = append(got, *tag.Name+"="+*tag.Value)
+	}
+	want := "campaign_uuid=c,tag_news=true,tag_a_b=true"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got tags %v, want %s", got, want)
+	}
+}
+
+func TestNewAWSSESInvalidConfig(t *testing.T) {
+	for _, cfg := range []string{
+		`{"api_version": "v3"}`,
+		`{"configuration_set": "events"}`,
+		`{"api_version": "v2", "topic": "weekly"}`,
+	} {
+		if _, err := NewAWSSES([]byte(cfg), nil); err == nil {
+			t.Errorf("expected error for %s", cfg)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger/phone"
+	"github.com/knadh/listmonk/models"
+)
+
+// defaultPhoneAttribs is where subscriber phone numbers are read from unless
+// configured otherwise.
+var defaultPhoneAttribs = []string{"phone"}
+
+// PhoneOpt is how an SMS messenger reads subscriber phone numbers, so that
+// inbound replies and routing can find the same numbers.
+type PhoneOpt struct {
+	// DefaultCountryCode is given to subscriber phone numbers without one,
+	// eg. "91" or "+91".
+	DefaultCountryCode string `json:"default_country_code"`
+	// PhoneAttribs are the subscriber attributes the phone number is read
+	// from, the first one set being used, eg. ["contact.mobile", "phone"].
+	PhoneAttribs []string `json:"phone_attribs"`
+}
+
+// ParsePhoneOpt reads the PhoneOpt of a messenger from its JSON config.
+// Messengers without one read the "phone" attribute.
+func ParsePhoneOpt(cfg []byte) (PhoneOpt, error) {
+	var o PhoneOpt
+	if len(cfg) > 0 {
+		if err := json.Unmarshal(cfg, &o); err != nil {
+			return o, err
+		}
+	}
+	return o, o.validate()
+}
+
+func (o PhoneOpt) validate() error {
+	if _, err := phone.CountryCode(o.DefaultCountryCode); err != nil {
+		return fmt.Errorf("invalid default_country_code")
+	}
+	if err := attribPaths(o.PhoneAttribs).validate(); err != nil {
+		return fmt.Errorf("invalid phone_attribs: %v", err)
+	}
+	return nil
+}
+
+// Attribs returns the paths of the attributes phone numbers are read from.
+func (o PhoneOpt) Attribs() []string {
+	if len(o.PhoneAttribs) == 0 {
+		return defaultPhoneAttribs
+	}
+	return o.PhoneAttribs
+}
+
+// Phone returns the subscriber phone number in attribs in E.164 format.
+// Missing and invalid numbers are invalid recipients.
+func (o PhoneOpt) Phone(attribs models.SubscriberAttribs) (string, error) {
+	v, ok := attribPaths(o.Attribs()).lookup(attribs)
+	if !ok {
+		return "", NewError(ErrInvalidRecipient, fmt.Errorf("could not find subscriber phone"))
+	}
+
+	p, err := phone.Normalize(v, o.DefaultCountryCode)
+	if err != nil {
+		return "", NewError(ErrInvalidRecipient, err)
+	}
+	return p, nil
+}
+
+// smsCfg holds the recipient options common to the SMS messengers.
+type smsCfg struct {
+	PhoneOpt
+	// SenderIDAttribs are subscriber attributes that override the messenger's
+	// sender, eg. with a number local to the subscriber.
+	SenderIDAttribs attribPaths `json:"sender_id_attribs"`
+}
+
+func (c smsCfg) validate() error {
+	if err := c.PhoneOpt.validate(); err != nil {
+		return err
+	}
+	if err := c.SenderIDAttribs.validate(); err != nil {
+		return fmt.Errorf("invalid sender_id_attribs: %v", err)
+	}
+	return nil
+}
+
+// phone returns the subscriber's phone number in E.164 format.
+func (c smsCfg) phone(msg Message) (string, error) {
+	return c.Phone(msg.Subscriber.Attribs)
+}
+
+// senderID returns the subscriber's sender ID override, or def.
+func (c smsCfg) senderID(msg Message, def string) string {
+	if s, ok := c.SenderIDAttribs.lookupString(msg.Subscriber.Attribs); ok {
+		return s
+	}
+	return def
+}
@@ -0,0 +1,40 @@
+package messenger
+
+import (
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestParsePhoneOpt(t *testing.T) {
+	o, err := ParsePhoneOpt([]byte(`{"access_key": "x", "default_country_code": "91", "phone_attribs": ["contact.mobile", "phone"]}`))
+	if err != nil {
+		t.Fatalf("ParsePhoneOpt: %v", err)
+	}
+	for want, attribs := range map[string]models.SubscriberAttribs{
+		"+919845012345": {"contact": map[string]interface{}{"mobile": "98450 12345"}},
+		"+14155550123":  {"phone": "+1 415 555 0123"},
+	} {
+		if got, err := o.Phone(attribs); err != nil || got != want {
+			t.Errorf("%v: got %s (%v), want %s", attribs, got, err, want)
+		}
+	}
+
+	// Messengers without phone options read "phone".
+	o, err = ParsePhoneOpt(nil)
+	if err != nil {
+		t.Fatalf("ParsePhoneOpt: %v", err)
+	}
+	if a := o.Attribs(); len(a) != 1 || a[0] != "phone" {
+		t.Errorf("unexpected default attribs: %v", a)
+	}
+	if _, err := o.Phone(models.SubscriberAttribs{"mobile": "+14155550123"}); Class(err) != ErrInvalidRecipient {
+		t.Errorf("expected invalid recipient, got %v", err)
+	}
+
+	for _, cfg := range []string{`{"default_country_code": "0"}`, `{"phone_attribs": ["contact..mobile"]}`, `{`} {
+		if _, err := ParsePhoneOpt([]byte(cfg)); err == nil {
+			t.Errorf("%s: expected error", cfg)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/pinpointsmsvoicev2"
+	"github.com/francoispqt/onelog"
+)
+
+func init() {
+	Register("pinpointsmsvoicev2", NewSMSVoiceV2)
+}
+
+type smsVoiceV2Cfg struct {
+	awsCfg
+	smsCfg
+	// OriginationIdentity is the phone number, sender ID or phone pool (ID or
+	// ARN) to send from.
+	OriginationIdentity string `json:"origination_identity"`
+	// ConfigurationSet is the name or ARN of the configuration set whose event
+	// destinations receive the delivery events.
+	ConfigurationSet string `json:"configuration_set"`
+	// MessageType is either "TRANSACTIONAL" or "PROMOTIONAL".
+	MessageType string `json:"message_type"`
+	// Keyword is the registered keyword (program name) for US short codes.
+	Keyword string `json:"keyword"`
+	// MaxPrice is the max amount in USD to spend per message part, eg. "0.05".
+	MaxPrice string `json:"max_price"`
+	// TTL is the number of seconds the message is valid for.
+	TTL int64 `json:"ttl"`
+	// DestinationCountryParameters are country specific registration
+	// parameters, eg. IN_ENTITY_ID and IN_TEMPLATE_ID for India.
+	DestinationCountryParameters map[string]string `json:"destination_country_parameters"`
+	// MessageFeedback enables message feedback, so that the delivery of each
+	// message can be confirmed with PutMessageFeedback.
+	MessageFeedback bool `json:"message_feedback"`
+	// DryRun validates messages without sending them.
+	DryRun bool `json:"dry_run"`
+	Log    bool `json:"log"`
+}
+
+type smsVoiceV2Messenger struct {
+	cfg    smsVoiceV2Cfg
+	client *pinpointsmsvoicev2.PinpointSMSVoiceV2
+
+	logger *onelog.Logger
+}
+
+func (s smsVoiceV2Messenger) Name() string {
+	return "pinpointsmsvoicev2"
+}
+
+// Push sends the sms through the AWS End User Messaging SMS API.
+func (s smsVoiceV2Messenger) Push(msg Message) error {
+	return s.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the sms through the AWS End User Messaging SMS API, bound
+// to ctx.
+func (s smsVoiceV2Messenger) PushContext(ctx context.Context, msg Message) error {
+	phone, err := s.cfg.phone(msg)
+	if err != nil {
+		return err
+	}
+
+	in := &pinpointsmsvoicev2.SendTextMessageInput{
+		DestinationPhoneNumber: aws.String(phone),
+		MessageBody:            aws.String(string(msg.Body)),
+		DryRun:                 aws.Bool(s.cfg.DryRun),
+		Context:                smsVoiceV2Context(msg),
+	}
+	if id := s.cfg.senderID(msg, s.cfg.OriginationIdentity); id != "" {
+		in.OriginationIdentity = aws.String(id)
+	}
+	if s.cfg.ConfigurationSet != "" {
+		in.ConfigurationSetName = aws.String(s.cfg.ConfigurationSet)
+	}
+	if s.cfg.MessageType != "" {
+		in.MessageType = aws.String(s.cfg.MessageType)
+	}
+	if s.cfg.Keyword != "" {
+		in.Keyword = aws.String(s.cfg.Keyword)
+	}
+	if s.cfg.MaxPrice != "" {
+		in.MaxPrice = aws.String(s.cfg.MaxPrice)
+	}
+	if s.cfg.TTL > 0 {
+		in.TimeToLive = aws.Int64(s.cfg.TTL)
+	}
+	if len(s.cfg.DestinationCountryParameters) > 0 {
+		in.DestinationCountryParameters = aws.StringMap(s.cfg.DestinationCountryParameters)
+	}
+
+	req, out := s.client.SendTextMessageRequest(in)
+	req.SetContext(ctx)
+	if s.cfg.MessageFeedback {
+		req.Handlers.Build.PushBack(enableMessageFeedback)
+	}
+	if err := req.Send(); err != nil {
+		return classifySMSVoiceV2Error(err)
+	}
+
+	if s.cfg.Log {
+		s.logger.InfoWith("successfully sent sms").String("phone", phone).String("message_id", aws.StringValue(out.MessageId)).Bool("dry_run", s.cfg.DryRun).Write()
+	}
+
+	return nil
+}
+
+func (s smsVoiceV2Messenger) Flush() error {
+	return nil
+}
+
+func (s smsVoiceV2Messenger) Close() error {
+	return nil
+}
+
+// enableMessageFeedback is a request build handler that sets
+// MessageFeedbackEnabled on a SendTextMessage request, as the field isn't
+// available in aws-sdk-go's SendTextMessageInput.
+func enableMessageFeedback(r *request.Request) {
+	if r.Error != nil {
+		return
+	}
+
+	b, err := io.ReadAll(r.GetBody())
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		r.Error = err
+		return
+	}
+	body["MessageFeedbackEnabled"] = true
+
+	b, err = json.Marshal(body)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	r.SetBufferBody(b)
+}
+
+// smsVoiceV2Context returns the custom data logged to the configuration set's
+// event destinations along with the delivery events of a message.
+func smsVoiceV2Context(msg Message) map[string]*string {
+	c := map[string]*string{
+		"subscriber_uuid": aws.String(msg.Subscriber.UUID),
+	}
+	if msg.Campaign != nil {
+		c["campaign_uuid"] = aws.String(msg.Campaign.UUID)
+	}
+	return c
+}
+
+// classifySMSVoiceV2Error wraps an End User Messaging error in its error class
+// using the reason the API gives for the failure.
+func classifySMSVoiceV2Error(err error) error {
+	var (
+		conflict   *pinpointsmsvoicev2.ConflictException
+		validation *pinpointsmsvoicev2.ValidationException
+		quota      *pinpointsmsvoicev2.ServiceQuotaExceededException
+	)
+	switch {
+	case errors.As(err, &conflict):
+		switch aws.StringValue(conflict.Reason) {
+		case pinpointsmsvoicev2.ConflictExceptionReasonDestinationPhoneNumberOptedOut,
+			pinpointsmsvoicev2.ConflictExceptionReasonDestinationPhoneNumberNotVerified:
+			return NewError(ErrInvalidRecipient, err)
+		}
+	case errors.As(err, &validation):
+		switch aws.StringValue(validation.Reason) {
+		case pinpointsmsvoicev2.ValidationExceptionReasonDestinationCountryBlocked,
+			pinpointsmsvoicev2.ValidationExceptionReasonCountryCodeMismatch,
+			pinpointsmsvoicev2.ValidationExceptionReasonInvalidIdentityForDestinationCountry:
+			return NewError(ErrInvalidRecipient, err)
+		}
+	case errors.As(err, &quota):
+		if aws.StringValue(quota.Reason) == pinpointsmsvoicev2.ServiceQuotaExceededExceptionReasonDailyDestinationCallLimit {
+			return NewError(ErrThrottled, err)
+		}
+	}
+
+	return classifyAWSError(err)
+}
+
+// NewSMSVoiceV2 creates new instance of the AWS End User Messaging SMS
+// (pinpoint-sms-voice-v2) messenger.
+func NewSMSVoiceV2(cfg []byte, l *onelog.Logger) (Messenger, error) {
+	var c smsVoiceV2Cfg
+	if err := json.Unmarshal(cfg, &c); err != nil {
+		return nil, err
+	}
+	if err := c.smsCfg.validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.MessageType {
+	case "", pinpointsmsvoicev2.MessageTypeTransactional, pinpointsmsvoicev2.MessageTypePromotional:
+	default:
+		return nil, fmt.Errorf("invalid message_type: %s", c.MessageType)
+	}
+	if c.TTL != 0 && c.TTL < 5 {
+		return nil, fmt.Errorf("invalid ttl: should be >= 5 seconds")
+	}
+
+	sess, err := newAWSSession(c.awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCredentials(sess); err != nil {
+		return nil, err
+	}
+
+	return smsVoiceV2Messenger{
+		client: pinpointsmsvoicev2.New(sess),
+		cfg:    c,
+		logger: l,
+	}, nil
+}
@@ -0,0 +1,150 @@
+package messenger
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/pinpointsmsvoicev2"
+	"github.com/knadh/listmonk/models"
+)
+
+// newTestSMSVoiceV2 returns a messenger talking to a stub API that records the
+// request bodies and responds with status and resp.
+func newTestSMSVoiceV2(t *testing.T, cfg smsVoiceV2Cfg, status int, resp string) (smsVoiceV2Messenger, *[]map[string]interface{}) {
+	t.Helper()
+
+	var reqs []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		reqs = append(reqs, body)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(status)
+		w.Write([]byte(resp))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.awsCfg = awsCfg{AccessKey: "test", SecretKey: "test", Region: "us-east-1", Endpoint: srv.URL}
+	sess, err := newAWSSession(cfg.awsCfg)
+	if err != nil {
+		t.Fatalf("newAWSSession: %v", err)
+	}
+
+	return smsVoiceV2Messenger{cfg: cfg, client: pinpointsmsvoicev2.New(sess)}, &reqs
+}
+
+func TestSMSVoiceV2Push(t *testing.T) {
+	m, reqs := newTestSMSVoiceV2(t, smsVoiceV2Cfg{
+		OriginationIdentity: "pool-123",
+		ConfigurationSet:    "events",
+		MessageFeedback:     true,
+		DryRun:              true,
+	}, http.StatusOK, `{"MessageId": "m1"}`)
+
+	msg := Message{
+		Body:       []byte("hello"),
+		Subscriber: models.Subscriber{UUID: "sub", Attribs: models.SubscriberAttribs{"phone": "+919845012345"}},
+		Campaign:   &models.Campaign{UUID: "camp"},
+	}
+	if err := m.Push(msg); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if len(*reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(*reqs))
+	}
+	got := (*reqs)[0]
+	for k, want := range map[string]interface{}{
+		"DestinationPhoneNumber": "+919845012345",
+		"OriginationIdentity":    "pool-123",
+		"ConfigurationSetName":   "events",
+		"MessageBody":            "hello",
+		"DryRun":                 true,
+		"MessageFeedbackEnabled": true,
+	} {
+		if got[k] != want {
+			t.Errorf("%s: got %v, want %v", k, got[k], want)
+		}
+	}
+	if ctx, _ := got["Context"].(map[string]interface{}); ctx["campaign_uuid"] != "camp" {
+		t.Errorf("unexpected context: %v", got["Context"])
+	}
+}
+
+func TestSMSVoiceV2OptedOut(t *testing.T) {
+	m, _ := newTestSMSVoiceV2(t, smsVoiceV2Cfg{}, http.StatusBadRequest,
+		`{"__type": "ConflictException", "Message": "opted out", "Reason": "DESTINATION_PHONE_NUMBER_OPTED_OUT"}`)
+
+	err := m.Push(Message{Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": "+15555550100"}}, Body: []byte("hi")})
+	if Class(err) != ErrInvalidRecipient {
+		t.Fatalf("expected invalid recipient, got %v", err)
+	}
+}
+
+func TestSMSVoiceV2Phone(t *testing.T) {
+	m, reqs := newTestSMSVoiceV2(t, smsVoiceV2Cfg{smsCfg: smsCfg{PhoneOpt: PhoneOpt{DefaultCountryCode: "91"}}},
+		http.StatusOK, `{"MessageId": "m1"}`)
+
+	push := func(phone interface{}) error {
+		return m.Push(Message{Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": phone}}, Body: []byte("hi")})
+	}
+
+	for _, p := range []interface{}{"98450 12345", float64(919845012345)} {
+		if err := push(p); err != nil {
+			t.Fatalf("Push %v: %v", p, err)
+		}
+	}
+	for _, r := range *reqs {
+		if r["DestinationPhoneNumber"] != "+919845012345" {
+			t.Errorf("unexpected destination: %v", r["DestinationPhoneNumber"])
+		}
+	}
+
+	// Invalid numbers are rejected without calling the API.
+	for _, p := range []interface{}{"98450 abc", nil, true} {
+		if err := push(p); Class(err) != ErrInvalidRecipient {
+			t.Errorf("%v: expected invalid recipient, got %v", p, err)
+		}
+	}
+	if len(*reqs) != 2 {
+		t.Errorf("expected 2 requests, got %d", len(*reqs))
+	}
+}
+
+func TestSMSVoiceV2RecipientAttribs(t *testing.T) {
+	m, reqs := newTestSMSVoiceV2(t, smsVoiceV2Cfg{
+		OriginationIdentity: "pool-123",
+		smsCfg: smsCfg{
+			PhoneOpt:        PhoneOpt{PhoneAttribs: []string{"attribs.contact.mobile", "whatsapp"}},
+			SenderIDAttribs: attribPaths{"contact.sender_id"},
+		},
+	}, http.StatusOK, `{"MessageId": "m1"}`)
+
+	for _, attribs := range []models.SubscriberAttribs{
+		{"contact": map[string]interface{}{"mobile": "+919845012345", "sender_id": "LOCAL"}},
+		{"phone": "+15555550100", "whatsapp": "+919845012345"},
+	} {
+		if err := m.Push(Message{Subscriber: models.Subscriber{Attribs: attribs}, Body: []byte("hi")}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+
+	for i, want := range []string{"LOCAL", "pool-123"} {
+		r := (*reqs)[i]
+		if r["DestinationPhoneNumber"] != "+919845012345" || r["OriginationIdentity"] != want {
+			t.Errorf("unexpected request %d: %v", i, r)
+		}
+	}
+
+	err := m.Push(Message{Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": "+15555550100"}}})
+	if Class(err) != ErrInvalidRecipient {
+		t.Errorf("expected invalid recipient, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package messenger
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/francoispqt/onelog"
+	"github.com/knadh/smtppool"
+)
+
+func init() {
+	Register("smtp", NewSMTP)
+}
+
+const (
+	smtpTLSNone     = "none"
+	smtpTLSTLS      = "TLS"
+	smtpTLSStartTLS = "STARTTLS"
+)
+
+type smtpServerCfg struct {
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	HelloHostname string `json:"hello_hostname"`
+
+	// AuthProtocol is one of "none", "plain", "login" or "cram".
+	AuthProtocol string `json:"auth_protocol"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+
+	// TLSType is one of "none", "TLS" or "STARTTLS".
+	TLSType       string `json:"tls_type"`
+	TLSSkipVerify bool   `json:"tls_skip_verify"`
+
+	MaxConns      int    `json:"max_conns"`
+	MaxMsgRetries int    `json:"max_msg_retries"`
+	IdleTimeout   string `json:"idle_timeout"`
+	WaitTimeout   string `json:"wait_timeout"`
+}
+
+type smtpCfg struct {
+	Servers []smtpServerCfg `json:"servers"`
+	Log     bool            `json:"log"`
+}
+
+type smtpMessenger struct {
+	cfg   smtpCfg
+	pools []*smtppool.Pool
+
+	// next is the index of the pool to send the next message through.
+	next *uint64
+
+	logger *onelog.Logger
+}
+
+func (s smtpMessenger) Name() string {
+	return "smtp"
+}
+
+// Push sends the email through one of the SMTP servers, round robin.
+func (s smtpMessenger) Push(msg Message) error {
+	if msg.Subscriber.Email == "" {
+		return NewError(ErrInvalidRecipient, fmt.Errorf("could not find subscriber email"))
+	}
+
+	n := atomic.AddUint64(s.next, 1)
+	if err := s.pools[n%uint64(len(s.pools))].Send(newEmail(msg)); err != nil {
+		return classifySMTPError(err)
+	}
+
+	if s.cfg.Log {
+		s.logger.InfoWith("successfully sent email").String("email", msg.Subscriber.Email).Write()
+	}
+
+	return nil
+}
+
+func (s smtpMessenger) Flush() error {
+	return nil
+}
+
+func (s smtpMessenger) Close() error {
+	for _, p := range s.pools {
+		p.Close()
+	}
+	return nil
+}
+
+// classifySMTPError wraps an SMTP error in its error class based on the
+// server's reply code. Errors that can't be classified are returned as is.
+func classifySMTPError(err error) error {
+	var tErr *textproto.Error
+	if !errors.As(err, &tErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return NewError(ErrProviderOutage, err)
+		}
+		return err
+	}
+
+	switch c := tErr.Code; {
+	case c == 421 || c == 450 || c == 451 || c == 452:
+		return NewError(ErrThrottled, err)
+	case c == 530 || c == 534 || c == 535:
+		return NewError(ErrAuth, err)
+	case c == 550 || c == 551 || c == 553:
+		return NewError(ErrInvalidRecipient, err)
+	case c == 552 || c == 554:
+		return NewError(ErrContentRejected, err)
+	case c >= 400 && c < 500:
+		return NewError(ErrProviderOutage, err)
+	}
+
+	return err
+}
+
+// newSMTPPool creates a connection pool to an SMTP server.
+func newSMTPPool(c smtpServerCfg) (*smtppool.Pool, error) {
+	if c.Host == "" {
+		return nil, fmt.Errorf("invalid host")
+	}
+	if c.Port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	opt := smtppool.Opt{
+		Host:              c.Host,
+		Port:              c.Port,
+		HelloHostname:     c.HelloHostname,
+		MaxConns:          c.MaxConns,
+		MaxMessageRetries: c.MaxMsgRetries,
+	}
+	if opt.MaxConns < 1 {
+		opt.MaxConns = 10
+	}
+
+	var err error
+	if c.IdleTimeout != "" {
+		if opt.IdleTimeout, err = time.ParseDuration(c.IdleTimeout); err != nil {
+			return nil, fmt.Errorf("invalid idle_timeout: %v", err)
+		}
+	}
+	if c.WaitTimeout != "" {
+		if opt.PoolWaitTimeout, err = time.ParseDuration(c.WaitTimeout); err != nil {
+			return nil, fmt.Errorf("invalid wait_timeout: %v", err)
+		}
+	}
+
+	switch strings.ToLower(c.AuthProtocol) {
+	case "", "none":
+	case "plain":
+		opt.Auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	case "login":
+		opt.Auth = &smtppool.LoginAuth{Username: c.Username, Password: c.Password}
+	case "cram":
+		opt.Auth = smtp.CRAMMD5Auth(c.Username, c.Password)
+	default:
+		return nil, fmt.Errorf("invalid auth_protocol: %s", c.AuthProtocol)
+	}
+
+	switch c.TLSType {
+	case "", smtpTLSNone:
+	case smtpTLSTLS, smtpTLSStartTLS:
+		opt.SSL = c.TLSType == smtpTLSTLS
+		opt.TLSConfig = &tls.Config{
+			ServerName:         c.Host,
+			InsecureSkipVerify: c.TLSSkipVerify,
+		}
+	default:
+		return nil, fmt.Errorf("invalid tls_type: %s", c.TLSType)
+	}
+
+	return smtppool.New(opt)
+}
+
+// NewSMTP creates new instance of smtp
+func NewSMTP(cfg []byte, l *onelog.Logger) (Messenger, error) {
+	var c smtpCfg
+	if err := json.Unmarshal(cfg, &c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Servers) == 0 {
+		return nil, fmt.Errorf("no servers configured")
+	}
+
+	pools := make([]*smtppool.Pool, 0, len(c.Servers))
+	for i, s := range c.Servers {
+		p, err := newSMTPPool(s)
+		if err != nil {
+			for _, p := range pools {
+				p.Close()
+			}
+			return nil, fmt.Errorf("server %d: %v", i, err)
+		}
+		pools = append(pools, p)
+	}
+
+	return smtpMessenger{
+		cfg:    c,
+		pools:  pools,
+		next:   new(uint64),
+		logger: l,
+	}, nil
+}
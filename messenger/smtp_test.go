@@ -0,0 +1,155 @@
+package messenger
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// smtpSink is a minimal SMTP server that records the messages it receives.
+// It rejects recipients listed in reject with a 550.
+type smtpSink struct {
+	ln     net.Listener
+	reject map[string]bool
+
+	mu   sync.Mutex
+	msgs []string
+	rcpt []string
+}
+
+func newSMTPSink(t *testing.T, reject ...string) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	s := &smtpSink{ln: ln, reject: map[string]bool{}}
+	for _, r := range reject {
+		s.reject[r] = true
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpSink) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve(c net.Conn) {
+	defer c.Close()
+	var (
+		r     = bufio.NewReader(c)
+		reply = func(l string) { fmt.Fprintf(c, "%s\r\n", l) }
+	)
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			addr := strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			if s.reject[addr] {
+				reply("550 no such user")
+				continue
+			}
+			s.mu.Lock()
+			s.rcpt = append(s.rcpt, addr)
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.mu.Lock()
+			s.msgs = append(s.msgs, b.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			// MAIL, RSET, NOOP.
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPPush(t *testing.T) {
+	sink := newSMTPSink(t, "bounce@example.com")
+
+	m, err := NewSMTP([]byte(fmt.Sprintf(`{"servers": [{"host": "127.0.0.1", "port": %d, "max_conns": 2, "wait_timeout": "2s"}]}`, sink.port())), nil)
+	if err != nil {
+		t.Fatalf("NewSMTP: %v", err)
+	}
+	defer m.Close()
+
+	msg := Message{
+		From:        "listmonk <noreply@example.com>",
+		Subject:     "Hello",
+		ContentType: ContentTypePlain,
+		Body:        []byte("Hello there"),
+		Subscriber:  models.Subscriber{Email: "user@example.com"},
+	}
+	if err := m.Push(msg); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	sink.mu.Lock()
+	if len(sink.msgs) != 1 || len(sink.rcpt) != 1 || sink.rcpt[0] != "user@example.com" {
+		t.Fatalf("unexpected delivery: %v %v", sink.rcpt, sink.msgs)
+	}
+	if !strings.Contains(sink.msgs[0], "Subject: Hello") || !strings.Contains(sink.msgs[0], "Hello there") {
+		t.Fatalf("unexpected message: %s", sink.msgs[0])
+	}
+	sink.mu.Unlock()
+
+	msg.Subscriber.Email = "bounce@example.com"
+	if err := m.Push(msg); Class(err) != ErrInvalidRecipient {
+		t.Fatalf("expected invalid recipient, got %v", err)
+	}
+}
+
+func TestNewSMTPInvalidConfig(t *testing.T) {
+	for _, cfg := range []string{
+		`{"servers": []}`,
+		`{"servers": [{"port": 25}]}`,
+		`{"servers": [{"host": "localhost", "port": 25, "tls_type": "SSL"}]}`,
+		`{"servers": [{"host": "localhost", "port": 25, "auth_protocol": "oauth"}]}`,
+		`{"servers": [{"host": "localhost", "port": 25, "idle_timeout": "soon"}]}`,
+	} {
+		if _, err := NewSMTP([]byte(cfg), nil); err == nil {
+			t.Errorf("expected error for %s", cfg)
+		}
+	}
+}
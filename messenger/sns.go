@@ -0,0 +1,193 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/francoispqt/onelog"
+)
+
+func init() {
+	Register("sns", NewSNS)
+}
+
+const (
+	snsSMSTypeTransactional = "Transactional"
+	snsSMSTypePromotional   = "Promotional"
+
+	snsSenderIDAttrib = "AWS.SNS.SMS.SenderID"
+)
+
+type snsCfg struct {
+	awsCfg
+	smsCfg
+	// SMSType is either "Transactional" or "Promotional".
+	SMSType           string `json:"sms_type"`
+	SenderID          string `json:"sender_id"`
+	OriginationNumber string `json:"origination_number"`
+	// MaxPrice is the max amount in USD to spend on a single SMS.
+	MaxPrice float64 `json:"max_price"`
+	Log      bool    `json:"log"`
+}
+
+type snsMessenger struct {
+	cfg    snsCfg
+	client *sns.SNS
+
+	// attribs are the SMS attributes set on every message.
+	attribs map[string]*sns.MessageAttributeValue
+
+	logger *onelog.Logger
+}
+
+func (s snsMessenger) Name() string {
+	return "sns"
+}
+
+// Push sends the sms through SNS API.
+func (s snsMessenger) Push(msg Message) error {
+	return s.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the sms through SNS API, bound to ctx.
+func (s snsMessenger) PushContext(ctx context.Context, msg Message) error {
+	phone, err := s.cfg.phone(msg)
+	if err != nil {
+		return err
+	}
+
+	attribs := s.attribs
+	if id := s.cfg.senderID(msg, s.cfg.SenderID); id != s.cfg.SenderID {
+		attribs = make(map[string]*sns.MessageAttributeValue, len(s.attribs)+1)
+		for k, v := range s.attribs {
+			attribs[k] = v
+		}
+		attribs[snsSenderIDAttrib] = snsString(id)
+	}
+
+	out, err := s.client.PublishWithContext(ctx, &sns.PublishInput{
+		PhoneNumber:       aws.String(phone),
+		Message:           aws.String(string(msg.Body)),
+		MessageAttributes: attribs,
+	})
+	if err != nil {
+		return classifySNSError(err)
+	}
+
+	if s.cfg.Log {
+		s.logger.InfoWith("successfully sent sms").String("phone", phone).String("message_id", aws.StringValue(out.MessageId)).Write()
+	}
+
+	return nil
+}
+
+func (s snsMessenger) Flush() error {
+	return nil
+}
+
+func (s snsMessenger) Close() error {
+	return nil
+}
+
+// classifySNSError wraps an SNS error in its error class. Publishing to a
+// phone number fails with InvalidParameter when the number is malformed.
+func classifySNSError(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case sns.ErrCodeInvalidParameterException, sns.ErrCodeInvalidParameterValueException:
+			// Other invalid parameters, eg. message attributes, are
+			// mistakes in the config.
+			if snsRecipientParam(awsErr.Message()) {
+				return NewError(ErrInvalidRecipient, err)
+			}
+		case sns.ErrCodeKMSThrottlingException:
+			return NewError(ErrThrottled, err)
+		case sns.ErrCodeAuthorizationErrorException:
+			return NewError(ErrAuth, err)
+		}
+	}
+
+	return classifyAWSError(err)
+}
+
+// snsRecipientParams are the parameters that identify the recipient, as named
+// in the messages of SNS' invalid parameter errors, eg. "Invalid parameter:
+// PhoneNumber Reason: +1 is not valid to publish to".
+var snsRecipientParams = []string{"PhoneNumber", "TargetArn", "Endpoint"}
+
+// snsRecipientParam reports whether an invalid parameter error message is
+// about the recipient.
+func snsRecipientParam(msg string) bool {
+	for _, p := range snsRecipientParams {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// snsAttribs returns the SMS message attributes for the config.
+func snsAttribs(c snsCfg) map[string]*sns.MessageAttributeValue {
+	out := map[string]*sns.MessageAttributeValue{}
+	if c.SMSType != "" {
+		out["AWS.SNS.SMS.SMSType"] = snsString(c.SMSType)
+	}
+	if c.SenderID != "" {
+		out[snsSenderIDAttrib] = snsString(c.SenderID)
+	}
+	if c.OriginationNumber != "" {
+		out["AWS.MM.SMS.OriginationNumber"] = snsString(c.OriginationNumber)
+	}
+	if c.MaxPrice > 0 {
+		out["AWS.SNS.SMS.MaxPrice"] = &sns.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.FormatFloat(c.MaxPrice, 'f', -1, 64)),
+		}
+	}
+
+	return out
+}
+
+// NewSNS creates new instance of sns
+func NewSNS(cfg []byte, l *onelog.Logger) (Messenger, error) {
+	var c snsCfg
+	if err := json.Unmarshal(cfg, &c); err != nil {
+		return nil, err
+	}
+	if err := c.smsCfg.validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.SMSType {
+	case "", snsSMSTypeTransactional, snsSMSTypePromotional:
+	default:
+		return nil, fmt.Errorf("invalid sms_type: %s", c.SMSType)
+	}
+
+	sess, err := newAWSSession(c.awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCredentials(sess); err != nil {
+		return nil, err
+	}
+
+	return snsMessenger{
+		client:  sns.New(sess),
+		cfg:     c,
+		attribs: snsAttribs(c),
+		logger:  l,
+	}, nil
+}
+
+func snsString(v string) *sns.MessageAttributeValue {
+	return &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+}
@@ -0,0 +1,156 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/knadh/listmonk/models"
+)
+
+// newTestSNS returns a messenger talking to a stub API that records the
+// request forms.
+func newTestSNS(t *testing.T, cfg snsCfg) (snsMessenger, *[]url.Values) {
+	t.Helper()
+
+	var reqs []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		reqs = append(reqs, r.PostForm)
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<PublishResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">
+<PublishResult><MessageId>m1</MessageId></PublishResult>
+<ResponseMetadata><RequestId>r1</RequestId></ResponseMetadata>
+</PublishResponse>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.awsCfg = awsCfg{AccessKey: "test", SecretKey: "test", Region: "us-east-1", Endpoint: srv.URL}
+	sess, err := newAWSSession(cfg.awsCfg)
+	if err != nil {
+		t.Fatalf("newAWSSession: %v", err)
+	}
+
+	return snsMessenger{cfg: cfg, client: sns.New(sess), attribs: snsAttribs(cfg)}, &reqs
+}
+
+// snsMessageAttribs returns the message attributes in a Publish request form.
+func snsMessageAttribs(v url.Values) map[string]string {
+	out := map[string]string{}
+	for n := 1; ; n++ {
+		prefix := "MessageAttributes.entry." + strconv.Itoa(n)
+		name := v.Get(prefix + ".Name")
+		if name == "" {
+			return out
+		}
+		out[name] = v.Get(prefix+".Value.DataType") + ":" + v.Get(prefix+".Value.StringValue")
+	}
+}
+
+func TestSNSPush(t *testing.T) {
+	m, reqs := newTestSNS(t, snsCfg{
+		SMSType:           snsSMSTypeTransactional,
+		SenderID:          "ACME",
+		OriginationNumber: "+15555550100",
+		MaxPrice:          0.5,
+		smsCfg:            smsCfg{SenderIDAttribs: attribPaths{"sender_id"}},
+	})
+
+	for _, attribs := range []models.SubscriberAttribs{
+		{"phone": "+919845012345"},
+		{"phone": "+919845012345", "sender_id": "LOCAL"},
+	} {
+		if err := m.Push(Message{Body: []byte("hello"), Subscriber: models.Subscriber{Attribs: attribs}}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	if len(*reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(*reqs))
+	}
+
+	for n, sender := range []string{"ACME", "LOCAL"} {
+		r := (*reqs)[n]
+		if r.Get("Action") != "Publish" || r.Get("PhoneNumber") != "+919845012345" || r.Get("Message") != "hello" {
+			t.Errorf("unexpected request %d: %v", n, r)
+		}
+
+		want := map[string]string{
+			"AWS.SNS.SMS.SMSType":          "String:Transactional",
+			"AWS.SNS.SMS.SenderID":         "String:" + sender,
+			"AWS.MM.SMS.OriginationNumber": "String:+15555550100",
+			"AWS.SNS.SMS.MaxPrice":         "Number:0.5",
+		}
+		got := snsMessageAttribs(r)
+		if len(got) != len(want) {
+			t.Errorf("request %d: expected attributes %v, got %v", n, want, got)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("request %d: %s: got %q, want %q", n, k, got[k], v)
+			}
+		}
+	}
+
+	// The override doesn't leak into the shared attributes.
+	if v := *m.attribs[snsSenderIDAttrib].StringValue; v != "ACME" {
+		t.Errorf("expected the default sender ID to be kept, got %s", v)
+	}
+}
+
+func TestSNSInvalidPhone(t *testing.T) {
+	m, reqs := newTestSNS(t, snsCfg{})
+
+	err := m.Push(Message{Body: []byte("hello"), Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": "12345"}}})
+	if Class(err) != ErrInvalidRecipient {
+		t.Errorf("expected invalid recipient, got %v", err)
+	}
+	if len(*reqs) != 0 {
+		t.Errorf("expected no requests, got %d", len(*reqs))
+	}
+}
+
+func TestSNSPushContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sess, err := newAWSSession(awsCfg{AccessKey: "test", SecretKey: "test", Region: "us-east-1", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("newAWSSession: %v", err)
+	}
+	m := snsMessenger{client: sns.New(sess)}
+	msg := Message{Body: []byte("hello"), Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": "+919845012345"}}}
+
+	// A request past the push's deadline is a provider outage.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.PushContext(ctx, msg); Class(err) != ErrProviderOutage {
+		t.Errorf("expected a provider outage, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the request to be cut off at the deadline, took %v", d)
+	}
+
+	// A cancelled request isn't.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := m.PushContext(ctx, msg); !errors.Is(err, context.Canceled) || Class(err) != nil {
+		t.Errorf("expected an unclassified cancellation, got %v (%v)", err, Class(err))
+	}
+}
@@ -1,26 +1,48 @@
 package messenger
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	
-	"github.com/twilio/twilio-go"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/twilio/twilio-go/client"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 
 	"github.com/francoispqt/onelog"
 )
 
+func init() {
+	Register("twilio", NewTwilio)
+}
+
 type twilioCfg struct {
-	AccountID   string `json:"account_id"`
-	AuthToken   string `json:"auth_token"`
-	SenderID    string `json:"sender_id"`
-	UploadPath  string `json:"upload_path"`
-	Log         bool   `json:"log"`
+	smsCfg
+	AccountID  string `json:"account_id"`
+	AuthToken  string `json:"auth_token"`
+	SenderID   string `json:"sender_id"`
+	UploadPath string `json:"upload_path"`
+	// StatusCallback is the public URL of this server's /events/twilio
+	// endpoint, which Twilio posts the delivery status of messages to.
+	StatusCallback string `json:"status_callback"`
+	Log            bool   `json:"log"`
 }
 
+// twilioTimeout is the deadline of a Twilio API request, as with twilio-go's
+// default client.
+const twilioTimeout = 10 * time.Second
+
 type twilioMessenger struct {
-	cfg    twilioCfg
-	client *twilio.RestClient
+	cfg twilioCfg
+
+	// client is the Twilio API client, copied for each push to bind its
+	// requests to the push's context.
+	client *client.Client
 
 	logger *onelog.Logger
 }
@@ -31,29 +53,37 @@ func (t twilioMessenger) Name() string {
 
 // Push sends the sms through twilio API.
 func (t twilioMessenger) Push(msg Message) error {
-	phone, ok := msg.Subscriber.Attribs["phone"].(string)
-	if !ok {
-		return fmt.Errorf("could not find subscriber phone")
+	return t.PushContext(context.Background(), msg)
+}
+
+// PushContext sends the sms through twilio API, bound to ctx.
+func (t twilioMessenger) PushContext(ctx context.Context, msg Message) error {
+	phone, err := t.cfg.phone(msg)
+	if err != nil {
+		return err
 	}
 
 	body := string(msg.Body)
 	payload := &twilioApi.CreateMessageParams{}
 	payload.SetTo(phone)
-	payload.SetFrom(t.cfg.SenderID)
+	payload.SetFrom(t.cfg.senderID(msg, t.cfg.SenderID))
 	payload.SetBody(body)
+	if t.cfg.StatusCallback != "" {
+		payload.SetStatusCallback(twilioStatusCallback(t.cfg.StatusCallback, msg))
+	}
 	if msg.Attachments != nil {
 		media := make([]string, 0, len(msg.Attachments))
 		for _, f := range msg.Attachments {
-			media = append(media,fmt.Sprintf("%s/%s",t.cfg.UploadPath,f.Name))
+			media = append(media, fmt.Sprintf("%s/%s", t.cfg.UploadPath, f.Name))
 		}
-		if (len(media) > 0) {
+		if len(media) > 0 {
 			payload.SetMediaUrl(media)
 		}
 	}
 
-	out, err := t.client.Api.CreateMessage(payload)
+	out, err := t.api(ctx).CreateMessage(payload)
 	if err != nil {
-		return err
+		return classifyTwilioError(err)
 	}
 
 	if t.cfg.Log {
@@ -64,6 +94,115 @@ func (t twilioMessenger) Push(msg Message) error {
 	return nil
 }
 
+// twilioStatusCallback returns the status callback URL of a message, which
+// identifies the subscriber and campaign in its query.
+func twilioStatusCallback(callback string, msg Message) string {
+	q := url.Values{}
+	q.Set("subscriber_uuid", msg.Subscriber.UUID)
+	if msg.Campaign != nil {
+		q.Set("campaign_uuid", msg.Campaign.UUID)
+	}
+
+	sep := "?"
+	if strings.Contains(callback, "?") {
+		sep = "&"
+	}
+	return callback + sep + q.Encode()
+}
+
+// newTwilioClient returns a Twilio API client sending requests with next.
+func newTwilioClient(c twilioCfg, next http.RoundTripper) *client.Client {
+	out := &client.Client{
+		Credentials: client.NewCredentials(c.AccountID, c.AuthToken),
+		HTTPClient: &http.Client{
+			Transport: next,
+			Timeout:   twilioTimeout,
+			// Same as twilio-go's default client.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	out.SetAccountSid(c.AccountID)
+	return out
+}
+
+// api returns the Twilio API with its requests bound to ctx. twilio-go doesn't
+// accept a context, so the client is copied with a transport attaching ctx to
+// every outgoing HTTP request. The copies share the connections and
+// credentials of the client.
+func (t twilioMessenger) api(ctx context.Context) *twilioApi.ApiService {
+	hc := *t.client.HTTPClient
+	hc.Transport = ctxTransport{ctx: ctx, next: t.client.HTTPClient.Transport}
+
+	c := *t.client
+	c.HTTPClient = &hc
+	return twilioApi.NewApiServiceWithClient(&c)
+}
+
+// ctxTransport is an http.RoundTripper that binds requests to a context.
+type ctxTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (c ctxTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return c.next.RoundTrip(r.WithContext(c.ctx))
+}
+
+// twilioRecipientErrors are Twilio error codes caused by the recipient.
+// See https://www.twilio.com/docs/api/errors
+var twilioRecipientErrors = map[int]bool{
+	21211: true, // Invalid 'To' phone number.
+	21214: true, // 'To' phone number cannot be reached.
+	21217: true, // Phone number does not appear to be valid.
+	21408: true, // Permission to send to this region not enabled.
+	21610: true, // Recipient has unsubscribed (replied STOP).
+	21612: true, // 'To' number is not reachable from the 'From' number.
+	21614: true, // 'To' number is not a valid mobile number.
+}
+
+// twilioContentErrors are Twilio error codes caused by the message content.
+var twilioContentErrors = map[int]bool{
+	21602: true, // Message body is required.
+	21617: true, // Message body exceeds the concatenated message limit.
+	21620: true, // Invalid media URL.
+	30007: true, // Message filtered by the carrier.
+}
+
+// classifyTwilioError wraps a Twilio API error in its error class. Errors that
+// can't be classified are returned as is.
+func classifyTwilioError(err error) error {
+	var tErr *client.TwilioRestError
+	if !errors.As(err, &tErr) {
+		// A cancelled push isn't the provider's doing.
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return NewError(ErrProviderOutage, err)
+		}
+		return err
+	}
+
+	switch {
+	case twilioRecipientErrors[tErr.Code]:
+		return NewError(ErrInvalidRecipient, err)
+	case twilioContentErrors[tErr.Code]:
+		return NewError(ErrContentRejected, err)
+	case tErr.Status == http.StatusTooManyRequests:
+		return NewError(ErrThrottled, err)
+	case tErr.Status == http.StatusUnauthorized || tErr.Status == http.StatusForbidden:
+		return NewError(ErrAuth, err)
+	case tErr.Status >= http.StatusInternalServerError:
+		return NewError(ErrProviderOutage, err)
+	}
+
+	return err
+}
+
 func (t twilioMessenger) Flush() error {
 	return nil
 }
@@ -78,6 +217,9 @@ func NewTwilio(cfg []byte, l *onelog.Logger) (Messenger, error) {
 	if err := json.Unmarshal(cfg, &c); err != nil {
 		return nil, err
 	}
+	if err := c.smsCfg.validate(); err != nil {
+		return nil, err
+	}
 
 	if c.AccountID == "" {
 		return nil, fmt.Errorf("invalid account_id")
@@ -91,15 +233,15 @@ func NewTwilio(cfg []byte, l *onelog.Logger) (Messenger, error) {
 	if c.UploadPath == "" {
 		return nil, fmt.Errorf("invalid upload_path")
 	}
-
-	svc := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: c.AccountID,
-		Password: c.AuthToken,
-	})
+	if c.StatusCallback != "" {
+		if u, err := url.Parse(c.StatusCallback); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid status_callback")
+		}
+	}
 
 	return twilioMessenger{
-		client: svc,
 		cfg:    c,
+		client: newTwilioClient(c, http.DefaultTransport),
 		logger: l,
 	}, nil
 }
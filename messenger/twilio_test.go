@@ -0,0 +1,105 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// hostTransport sends requests to the host of a test server.
+type hostTransport struct {
+	u *url.URL
+}
+
+func (h hostTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = h.u.Scheme, h.u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestTwilio returns a messenger sending its API requests to h.
+func newTestTwilio(t *testing.T, h http.HandlerFunc) twilioMessenger {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+
+	cfg := twilioCfg{AccountID: "AC1", AuthToken: "token", SenderID: "+15005550006"}
+	return twilioMessenger{cfg: cfg, client: newTwilioClient(cfg, hostTransport{u})}
+}
+
+func TestTwilioStatusCallback(t *testing.T) {
+	msg := Message{
+		Subscriber: models.Subscriber{UUID: "sub"},
+		Campaign:   &models.Campaign{UUID: "camp"},
+	}
+
+	for cb, want := range map[string]string{
+		"https://example.com/events/twilio":     "https://example.com/events/twilio?campaign_uuid=camp&subscriber_uuid=sub",
+		"https://example.com/events/twilio?a=b": "https://example.com/events/twilio?a=b&campaign_uuid=camp&subscriber_uuid=sub",
+	} {
+		if got := twilioStatusCallback(cb, msg); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestTwilioPushContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	m := newTestTwilio(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("To") == "+15005550006" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"sid": "SM1", "status": "queued"}`))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	msg := func(phone string) Message {
+		return Message{Body: []byte("hi"), Subscriber: models.Subscriber{Attribs: models.SubscriberAttribs{"phone": phone}}}
+	}
+
+	if err := m.PushContext(context.Background(), msg("+15005550006")); err != nil {
+		t.Fatalf("PushContext: %v", err)
+	}
+
+	// A request past the push's deadline is a provider outage.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.PushContext(ctx, msg("+919845012345")); Class(err) != ErrProviderOutage {
+		t.Errorf("expected a provider outage, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the request to be cut off at the deadline, took %v", d)
+	}
+
+	// A cancelled request isn't.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := m.PushContext(ctx, msg("+919845012345")); !errors.Is(err, context.Canceled) || Class(err) != nil {
+		t.Errorf("expected an unclassified cancellation, got %v (%v)", err, Class(err))
+	}
+}
+
+func TestNewTwilioTimeout(t *testing.T) {
+	m, err := NewTwilio([]byte(`{"account_id": "AC1", "auth_token": "token", "sender_id": "+15005550006", "upload_path": "https://example.com"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := m.(twilioMessenger).client.HTTPClient.Timeout; d != twilioTimeout {
+		t.Errorf("expected a %v timeout, got %v", twilioTimeout, d)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "listmonk_messenger"
+
+var (
+	metricReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received via webhooks, one per recipient.",
+	}, []string{"messenger"})
+
+	metricSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "Messages successfully pushed to a messenger.",
+	}, []string{"messenger"})
+
+	metricFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that could not be pushed, by error class.",
+	}, []string{"messenger", "error"})
+
+	metricRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "push_retries_total",
+		Help:      "Push attempts retried after a transient error.",
+	}, []string{"messenger"})
+
+	metricPushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "push_duration_seconds",
+		Help:      "Latency of individual push attempts to a messenger.",
+		Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"messenger"})
+
+	metricRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Messages delayed or rejected by the messenger's rate limit.",
+	}, []string{"messenger"})
+
+	metricQueueErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_errors_total",
+		Help:      "Errors of queue workers claiming and acknowledging jobs, by operation.",
+	}, []string{"messenger", "op"})
+
+	metricEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Delivery events received from providers, by type.",
+	}, []string{"source", "type"})
+
+	metricQueueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "queue_depth"),
+		"Messages waiting in the on-disk queue.",
+		[]string{"messenger"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(metricReceived, metricSent, metricFailed, metricRetries, metricPushDuration, metricEvents, metricRateLimited, metricQueueErrors)
+}
+
+// recordPush records the outcome of a push in the metrics.
+func recordPush(name string, attempts int, err error) {
+	if attempts > 1 {
+		metricRetries.WithLabelValues(name).Add(float64(attempts - 1))
+	}
+
+	if err == nil {
+		metricSent.WithLabelValues(name).Inc()
+		return
+	}
+
+	class := "unknown"
+	if c := messenger.Class(err); c != nil {
+		class = c.Code
+	}
+	metricFailed.WithLabelValues(name, class).Inc()
+}
+
+// instrumented is a Messenger that measures the latency of every push.
+type instrumented struct {
+	messenger.Messenger
+	name string
+}
+
+// instrument wraps m to record push latencies under name.
+func instrument(name string, m messenger.Messenger) messenger.Messenger {
+	return instrumented{Messenger: m, name: name}
+}
+
+func (i instrumented) Push(msg messenger.Message) error {
+	return i.PushContext(context.Background(), msg)
+}
+
+func (i instrumented) PushContext(ctx context.Context, msg messenger.Message) error {
+	start := time.Now()
+	err := messenger.PushContext(ctx, i.Messenger, msg)
+	metricPushDuration.WithLabelValues(i.name).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// queueCollector exports the depth of every messenger's queue.
+type queueCollector struct {
+	q     *queue.Queue
+	names []string
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricQueueDepth
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range c.names {
+		n, err := c.q.Len(name)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(metricQueueDepth, prometheus.GaugeValue, float64(n), name)
+	}
+}
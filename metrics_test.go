@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	// The metrics are global, so the messenger has a name of its own and its
+	// series are reset for repeated runs.
+	metricReceived.DeleteLabelValues("metrics")
+	metricSent.DeleteLabelValues("metrics")
+	metricFailed.DeleteLabelValues("metrics", messenger.ErrInvalidRecipient.Code)
+
+	m := &stubMessenger{name: "metrics", errs: []error{messenger.NewError(messenger.ErrInvalidRecipient, errors.New("no phone"))}}
+	if code, resp := sendPostback(t, m, batchPostback); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", code, resp)
+	}
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	for _, want := range []string{
+		`listmonk_messenger_messages_received_total{messenger="metrics"} 3`,
+		`listmonk_messenger_messages_sent_total{messenger="metrics"} 2`,
+		`listmonk_messenger_messages_failed_total{error="invalid_recipient",messenger="metrics"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("expected %s in the metrics", want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateLimitWait holds pushes until the limit allows them.
+	rateLimitWait = "wait"
+	// rateLimitReject responds to webhooks over the limit with a 429.
+	rateLimitReject = "reject"
+)
+
+var errRateLimitDeadline = errors.New("rate limit doesn't allow the message before the deadline")
+
+// rateLimitCfg is the token bucket limiting the push rate of a messenger.
+type rateLimitCfg struct {
+	// Rate is the number of messages per second. 0 disables the limit.
+	Rate float64 `koanf:"rate"`
+	// Burst is the number of messages that can be pushed at once. Defaults to
+	// the rate, rounded up.
+	Burst int    `koanf:"burst"`
+	Mode  string `koanf:"mode"`
+}
+
+// rateLimiter limits the push rate of a messenger.
+type rateLimiter struct {
+	lim    *rate.Limiter
+	reject bool
+}
+
+// newRateLimiter returns the limiter for the config, or nil if it's disabled.
+func newRateLimiter(c rateLimitCfg) (*rateLimiter, error) {
+	if c.Rate < 0 {
+		return nil, fmt.Errorf("invalid rate: %v", c.Rate)
+	}
+	if c.Rate == 0 {
+		return nil, nil
+	}
+
+	burst := c.Burst
+	if burst < 1 {
+		burst = int(math.Ceil(c.Rate))
+	}
+
+	l := &rateLimiter{lim: rate.NewLimiter(rate.Limit(c.Rate), burst)}
+	switch c.Mode {
+	case "", rateLimitWait:
+	case rateLimitReject:
+		l.reject = true
+	default:
+		return nil, fmt.Errorf("invalid mode: %s", c.Mode)
+	}
+
+	return l, nil
+}
+
+// wait blocks until a message can be pushed or ctx is done. It reports whether
+// it had to wait. If the limit doesn't allow the message before ctx's
+// deadline, it fails right away as throttled.
+func (l *rateLimiter) wait(ctx context.Context) (bool, error) {
+	r := l.lim.Reserve()
+	d := r.Delay()
+	if d == 0 {
+		return false, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		r.Cancel()
+		return true, messenger.NewError(messenger.ErrThrottled, errRateLimitDeadline)
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true, nil
+	case <-ctx.Done():
+		r.Cancel()
+		return true, ctx.Err()
+	}
+}
+
+// admit takes n messages off the limit if they can be pushed right away, and
+// returns the number admitted and a func that puts them back. If they can't,
+// it returns the time to retry after. Only up to the burst is admitted, as
+// larger postbacks could never be, and the rest have to wait for the limit.
+func (l *rateLimiter) admit(n int) (int, func(), time.Duration) {
+	if b := l.lim.Burst(); n > b {
+		n = b
+	}
+
+	r := l.lim.ReserveN(time.Now(), n)
+	if d := r.Delay(); d > 0 {
+		r.Cancel()
+		return 0, nil, d
+	}
+	return n, r.Cancel, 0
+}
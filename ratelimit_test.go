@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/francoispqt/onelog"
+	"github.com/go-chi/chi"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+// stubMessenger is a messenger that returns errs in order, then succeeds, and
+// records the messages it's pushed.
+type stubMessenger struct {
+	name string
+
+	mu   sync.Mutex
+	errs []error
+	msgs []messenger.Message
+}
+
+func (s *stubMessenger) Name() string { return s.name }
+
+func (s *stubMessenger) Push(msg messenger.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgs = append(s.msgs, msg)
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *stubMessenger) Flush() error { return nil }
+func (s *stubMessenger) Close() error { return nil }
+
+func (s *stubMessenger) pushed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.msgs)
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l, err := newRateLimiter(rateLimitCfg{Rate: 20, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if waited, _ := l.wait(context.Background()); waited {
+		t.Error("expected the first message not to wait")
+	}
+	start := time.Now()
+	if waited, _ := l.wait(context.Background()); !waited {
+		t.Error("expected the second message to wait")
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("expected to wait ~50ms, waited %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.wait(ctx); err == nil {
+		t.Error("expected a cancelled wait to fail")
+	}
+
+	for _, c := range []rateLimitCfg{{Rate: -1}, {Rate: 1, Mode: "drop"}} {
+		if _, err := newRateLimiter(c); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+	if l, _ := newRateLimiter(rateLimitCfg{}); l != nil {
+		t.Error("expected no limiter for a 0 rate")
+	}
+}
+
+func TestPostbackRateLimitReject(t *testing.T) {
+	lim, err := newRateLimiter(rateLimitCfg{Rate: 0.5, Burst: 1, Mode: rateLimitReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &stubMessenger{name: "sms"}
+	app := &App{
+		logger:      onelog.New(os.Stderr, 0),
+		concurrency: 1,
+		messengers:  map[string]messenger.Messenger{"sms": m},
+		opts:        map[string]msgrOpts{"sms": {limiter: lim}},
+	}
+
+	r := chi.NewRouter()
+	r.Post("/webhook/{provider}", wrap(app, handlePostback))
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/sms",
+			strings.NewReader(`{"body": "hi", "recipients": [{"uuid": "sub", "attribs": {"phone": "+1"}}]}`)))
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	w := send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "2" {
+		t.Errorf("expected Retry-After 2, got %q", ra)
+	}
+	if n := m.pushed(); n != 1 {
+		t.Errorf("expected 1 push, got %d", n)
+	}
+
+	// Recipients of a postback past the burst wait for the limit.
+	lim, err = newRateLimiter(rateLimitCfg{Rate: 10, Burst: 1, Mode: rateLimitReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.opts["sms"] = msgrOpts{limiter: lim}
+	m.msgs = nil
+
+	start := time.Now()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/sms", strings.NewReader(
+		`{"body": "hi", "recipients": [{"uuid": "a"}, {"uuid": "b"}, {"uuid": "c"}, {"uuid": "d"}]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if n := m.pushed(); n != 4 {
+		t.Errorf("expected 4 pushes, got %d", n)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("expected the pushes past the burst to wait ~300ms, took %v", d)
+	}
+	if w := send(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 right after, got %d", w.Code)
+	}
+}
+
+func TestPostbackRateLimitDeadline(t *testing.T) {
+	lim, err := newRateLimiter(rateLimitCfg{Rate: 10, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &stubMessenger{name: "sms"}
+	app := &App{
+		logger:       onelog.New(os.Stderr, 0),
+		concurrency:  1,
+		pushDeadline: 150 * time.Millisecond,
+		messengers:   map[string]messenger.Messenger{"sms": m},
+		opts:         map[string]msgrOpts{"sms": {limiter: lim}},
+	}
+
+	r := chi.NewRouter()
+	r.Post("/webhook/{provider}", wrap(app, handlePostback))
+
+	// Recipients the limit doesn't allow before the deadline fail right away.
+	start := time.Now()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook/sms", strings.NewReader(
+		`{"body": "hi", "recipients": [{"uuid": "a"}, {"uuid": "b"}, {"uuid": "c"}, {"uuid": "d"}]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("expected the response within the deadline, took %v", d)
+	}
+	if n := m.pushed(); n != 2 {
+		t.Errorf("expected 2 pushes, got %d", n)
+	}
+
+	var resp struct {
+		Data []pushResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range []string{"", "", messenger.ErrThrottled.Code, messenger.ErrThrottled.Code} {
+		if got := resp.Data[n].Code; got != want {
+			t.Errorf("recipient %d: got code %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+// defaultMaxBackoff caps the backoff if max_backoff isn't set.
+const defaultMaxBackoff = 30 * time.Second
+
+// retryPolicy controls how failed pushes to a messenger are retried.
+type retryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	MaxAttempts int           `koanf:"max_attempts"`
+	BaseBackoff time.Duration `koanf:"base_backoff"`
+	// MaxBackoff caps the backoff. Defaults to defaultMaxBackoff.
+	MaxBackoff time.Duration `koanf:"max_backoff"`
+	// Jitter randomises each backoff between half and the full duration.
+	Jitter bool `koanf:"jitter"`
+}
+
+// do calls fn until it succeeds, returns a non-transient error, ctx is cancelled
+// or the attempts are exhausted. It doesn't back off past ctx's deadline, so
+// that retries never outlast it. It returns the number of attempts made and the
+// last error.
+func (p retryPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for n := 1; ; n++ {
+		if err = fn(); err == nil || n >= attempts || !messenger.IsTransient(err) {
+			return n, err
+		}
+
+		d := p.backoff(n)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+			return n, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return n, err
+		case <-time.After(d):
+		}
+	}
+}
+
+// backoff returns the wait before the attempt following attempt n.
+func (p retryPolicy) backoff(n int) time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := p.BaseBackoff
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	if p.Jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d
+}
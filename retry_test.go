@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for n, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		if got := p.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+
+	// Without a max_backoff, the default caps it before it overflows.
+	p = retryPolicy{BaseBackoff: time.Second}
+	if got := p.backoff(80); got != defaultMaxBackoff {
+		t.Errorf("backoff(80) = %v, want %v", got, defaultMaxBackoff)
+	}
+
+	p = retryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: true}
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(3); got < 200*time.Millisecond || got > 400*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %v", got)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := retryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond}
+
+	// Throttling is retried until the attempts run out.
+	var calls int
+	n, err := p.do(context.Background(), func() error {
+		calls++
+		return messenger.NewError(messenger.ErrThrottled, errors.New("slow down"))
+	})
+	if n != 3 || calls != 3 || err == nil {
+		t.Fatalf("expected 3 failed attempts, got %d (%d calls): %v", n, calls, err)
+	}
+
+	// Permanent errors are not retried.
+	calls = 0
+	n, _ = p.do(context.Background(), func() error {
+		calls++
+		return messenger.NewError(messenger.ErrInvalidRecipient, errors.New("bad phone"))
+	})
+	if n != 1 || calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", n)
+	}
+
+	// Success after a transient failure.
+	calls = 0
+	n, err = p.do(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return messenger.NewError(messenger.ErrProviderOutage, errors.New("unavailable"))
+		}
+		return nil
+	})
+	if n != 2 || err != nil {
+		t.Fatalf("expected success on the 2nd attempt, got %d: %v", n, err)
+	}
+
+	// Backoffs past the deadline are not waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls = 0
+	start := time.Now()
+	n, err = retryPolicy{MaxAttempts: 3, BaseBackoff: time.Second}.do(ctx, func() error {
+		calls++
+		return messenger.NewError(messenger.ErrProviderOutage, errors.New("unavailable"))
+	})
+	if n != 1 || err == nil {
+		t.Fatalf("expected a single failed attempt, got %d: %v", n, err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("expected to give up right away, took %v", d)
+	}
+
+	// Unclassified errors are not retried.
+	calls = 0
+	n, _ = p.do(context.Background(), func() error {
+		calls++
+		return errors.New("unknown")
+	})
+	if n != 1 {
+		t.Fatalf("expected a single attempt, got %d", n)
+	}
+}
+
+func TestPushDeadline(t *testing.T) {
+	for timeout, want := range map[time.Duration]time.Duration{
+		0:                      0,
+		5 * time.Second:        4500 * time.Millisecond,
+		600 * time.Millisecond: 300 * time.Millisecond,
+	} {
+		if got := pushDeadline(timeout); got != want {
+			t.Errorf("pushDeadline(%v) = %v, want %v", timeout, got, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+// routeName is the path of the routing webhook, /webhook/route, which a
+// messenger can't be named as.
+const routeName = "route"
+
+// routeRule sends the recipients it matches with a messenger. All the
+// conditions that are set have to match. A rule without conditions matches
+// every recipient.
+type routeRule struct {
+	Messenger string `koanf:"messenger"`
+
+	// Tags match campaigns with any of the tags.
+	Tags []string `koanf:"tags"`
+	// CampaignName is a regexp matched against the campaign name.
+	CampaignName string `koanf:"campaign_name"`
+	// Attribs match subscribers whose attributes have the values, ignoring
+	// case, eg. {country = "IN", preferred_channel = "sms"}.
+	Attribs map[string]string `koanf:"attribs"`
+	// PhonePrefixes match subscribers whose phone, in E.164 format as read by
+	// the messenger, starts with any of them.
+	PhonePrefixes []string `koanf:"phone_prefixes"`
+
+	nameRe *regexp.Regexp
+	phone  messenger.PhoneOpt
+}
+
+// router picks the messenger of each recipient using the first matching rule.
+type router struct {
+	rules []routeRule
+}
+
+func newRouter(rules []routeRule, msgrs map[string]messenger.Messenger, opts map[string]msgrOpts) (*router, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules configured")
+	}
+	if _, ok := msgrs[routeName]; ok {
+		return nil, fmt.Errorf("a messenger can't be named %s", routeName)
+	}
+
+	for i, r := range rules {
+		if _, ok := msgrs[r.Messenger]; !ok {
+			return nil, fmt.Errorf("rule %d: messenger %s is not loaded", i, r.Messenger)
+		}
+		rules[i].phone = opts[r.Messenger].phone
+
+		if r.CampaignName != "" {
+			re, err := regexp.Compile(r.CampaignName)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid campaign_name: %v", i, err)
+			}
+			rules[i].nameRe = re
+		}
+	}
+
+	return &router{rules: rules}, nil
+}
+
+// route returns the messenger of the message, or an empty string if no rule
+// matches.
+func (rt *router) route(msg messenger.Message) string {
+	for _, r := range rt.rules {
+		if r.match(msg) {
+			return r.Messenger
+		}
+	}
+	return ""
+}
+
+func (r routeRule) match(msg messenger.Message) bool {
+	if len(r.Tags) > 0 {
+		if msg.Campaign == nil || !hasAny(msg.Campaign.Tags, r.Tags) {
+			return false
+		}
+	}
+
+	if r.nameRe != nil {
+		if msg.Campaign == nil || !r.nameRe.MatchString(msg.Campaign.Name) {
+			return false
+		}
+	}
+
+	for k, want := range r.Attribs {
+		v, ok := msg.Subscriber.Attribs[k]
+		if !ok || !strings.EqualFold(fmt.Sprint(v), want) {
+			return false
+		}
+	}
+
+	if len(r.PhonePrefixes) > 0 {
+		phone, err := r.phone.Phone(msg.Subscriber.Attribs)
+		ok := false
+		for _, p := range r.PhonePrefixes {
+			if err == nil && strings.HasPrefix(phone, p) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleRoute pushes a message to every recipient in the postback using the
+// messenger its routing rule picks.
+func handleRoute(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	data, ok := readPostback(w, r)
+	if !ok {
+		return
+	}
+
+	msgs := makeMessages(data)
+	names := make([]string, len(msgs))
+	for n, m := range msgs {
+		names[n] = app.router.route(m)
+	}
+
+	dispatch(w, r, app, names, msgs, true)
+}
+
+func hasAny(list, vals []string) bool {
+	for _, v := range vals {
+		for _, l := range list {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
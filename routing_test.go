@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/knadh/listmonk/models"
+)
+
+const testRoutes = `
+[routing]
+enabled = true
+
+[[routing.rules]]
+messenger = "whatsapp"
+attribs = { preferred_channel = "WhatsApp" }
+
+[[routing.rules]]
+messenger = "pinpoint"
+tags = ["sms"]
+phone_prefixes = ["+91"]
+
+[[routing.rules]]
+messenger = "twilio"
+campaign_name = "(?i)^alert"
+
+[[routing.rules]]
+messenger = "ses"
+tags = ["newsletter"]
+`
+
+func loadTestRouter(t *testing.T, msgrs map[string]messenger.Messenger, opts map[string]msgrOpts) *router {
+	t.Helper()
+
+	k := koanf.New(".")
+	if err := k.Load(rawbytes.Provider([]byte(testRoutes)), toml.Parser()); err != nil {
+		t.Fatal(err)
+	}
+	var rules []routeRule
+	if err := k.Unmarshal("routing.rules", &rules); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := newRouter(rules, msgrs, opts)
+	if err != nil {
+		t.Fatalf("newRouter: %v", err)
+	}
+	return rt
+}
+
+func TestRouter(t *testing.T) {
+	msgrs := map[string]messenger.Messenger{}
+	for _, n := range []string{"whatsapp", "pinpoint", "twilio", "ses"} {
+		msgrs[n] = &stubMessenger{name: n}
+	}
+	// Phone prefixes match numbers as the messenger reads them.
+	rt := loadTestRouter(t, msgrs, map[string]msgrOpts{"pinpoint": {phone: messenger.PhoneOpt{
+		DefaultCountryCode: "91",
+		PhoneAttribs:       []string{"contact.mobile", "phone"},
+	}}})
+
+	msg := func(name string, tags []string, attribs models.SubscriberAttribs) messenger.Message {
+		return messenger.Message{
+			Subscriber: models.Subscriber{Attribs: attribs},
+			Campaign:   &models.Campaign{Name: name, Tags: tags},
+		}
+	}
+	for _, c := range []struct {
+		msg  messenger.Message
+		want string
+	}{
+		{msg("Offers", []string{"sms"}, models.SubscriberAttribs{"preferred_channel": "whatsapp"}), "whatsapp"},
+		{msg("Offers", []string{"sms"}, models.SubscriberAttribs{"phone": "+919845012345"}), "pinpoint"},
+		{msg("Offers", []string{"sms"}, models.SubscriberAttribs{"contact": map[string]interface{}{"mobile": "98450 12345"}}), "pinpoint"},
+		{msg("Offers", []string{"sms"}, models.SubscriberAttribs{"phone": float64(919845012345)}), "pinpoint"},
+		{msg("Offers", []string{"sms"}, models.SubscriberAttribs{"phone": "+1 500 555 0006"}), ""},
+		{msg("ALERT: outage", []string{"sms"}, models.SubscriberAttribs{"phone": "+15005550006"}), "twilio"},
+		{msg("Weekly", []string{"newsletter"}, nil), "ses"},
+		{msg("Weekly", nil, nil), ""},
+		{messenger.Message{}, ""},
+	} {
+		if got := rt.route(c.msg); got != c.want {
+			t.Errorf("%s %v %v: got %q, want %q", c.msg.Campaign.Name, c.msg.Campaign.Tags, c.msg.Subscriber.Attribs, got, c.want)
+		}
+	}
+
+	if _, err := newRouter([]routeRule{{Messenger: "sns"}}, msgrs, nil); err == nil {
+		t.Error("expected error for a messenger that isn't loaded")
+	}
+	if _, err := newRouter([]routeRule{{Messenger: "ses", CampaignName: "("}}, msgrs, nil); err == nil {
+		t.Error("expected error for an invalid campaign_name")
+	}
+}
+
+func TestHandleRoute(t *testing.T) {
+	msgrs := map[string]messenger.Messenger{}
+	for _, n := range []string{"whatsapp", "pinpoint", "twilio", "ses"} {
+		msgrs[n] = &stubMessenger{name: n}
+	}
+	app := &App{
+		logger:      onelog.New(os.Stderr, 0),
+		concurrency: 2,
+		messengers:  msgrs,
+		opts:        map[string]msgrOpts{},
+		router:      loadTestRouter(t, msgrs, nil),
+	}
+
+	w := httptest.NewRecorder()
+	wrap(app, handleRoute)(w, httptest.NewRequest(http.MethodPost, "/webhook/route", strings.NewReader(`{
+		"body": "hi",
+		"campaign": {"name": "Offers", "tags": ["sms"]},
+		"recipients": [
+			{"uuid": "a", "attribs": {"phone": "+919845012345"}},
+			{"uuid": "b", "attribs": {"preferred_channel": "whatsapp"}},
+			{"uuid": "c", "attribs": {"phone": "+15005550006"}}
+		]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Data []pushResult `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []pushResult{
+		{UUID: "a", Status: statusSent, Messenger: "pinpoint"},
+		{UUID: "b", Status: statusSent, Messenger: "whatsapp"},
+		{UUID: "c", Status: statusFailed, Messenger: "", Code: codeNoRoute, Error: "no messenger matches the recipient"},
+	}
+	for n, res := range resp.Data {
+		if !reflect.DeepEqual(res, want[n]) {
+			t.Errorf("recipient %d: got %+v, want %+v", n, res, want[n])
+		}
+	}
+}
+
+func TestHandleRouteQueued(t *testing.T) {
+	msgrs := map[string]messenger.Messenger{}
+	for _, n := range []string{"whatsapp", "pinpoint", "twilio", "ses"} {
+		msgrs[n] = &stubMessenger{name: n}
+	}
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	app := &App{
+		logger:     onelog.New(os.Stderr, 0),
+		messengers: msgrs,
+		opts:       map[string]msgrOpts{},
+		router:     loadTestRouter(t, msgrs, nil),
+		queue:      q,
+	}
+
+	w := httptest.NewRecorder()
+	wrap(app, handleRoute)(w, httptest.NewRequest(http.MethodPost, "/webhook/route", strings.NewReader(`{
+		"body": "hi",
+		"campaign": {"name": "Offers", "tags": ["sms"]},
+		"recipients": [
+			{"uuid": "a", "attribs": {"phone": "+919845012345"}},
+			{"uuid": "b", "attribs": {"preferred_channel": "whatsapp"}},
+			{"uuid": "c", "attribs": {"phone": "+919845012346"}},
+			{"uuid": "d"}
+		]}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body)
+	}
+
+	for name, want := range map[string]int{"pinpoint": 2, "whatsapp": 1, "twilio": 0} {
+		if n, _ := q.Len(name); n != want {
+			t.Errorf("%s: expected %d queued, got %d", name, want, n)
+		}
+	}
+}
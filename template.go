@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/listmonk/models"
+)
+
+var (
+	reHTMLSkip  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>|<!--.*?-->`)
+	reHTMLBreak = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr|/h[1-6])\b[^>]*>`)
+	reHTMLTag   = regexp.MustCompile(`<[^>]*>`)
+	reSpaces    = regexp.MustCompile(`[ \t\r\f\v]+`)
+	reBreaks    = regexp.MustCompile(`\s*\n\s*`)
+)
+
+// templateFuncs are the helpers available to body templates.
+var templateFuncs = template.FuncMap{
+	"truncate":  truncate,
+	"stripHTML": stripHTML,
+	"upper":     strings.ToUpper,
+	"default":   defaultValue,
+}
+
+// templateData is what body templates are evaluated with.
+type templateData struct {
+	Subscriber models.Subscriber
+	Campaign   *models.Campaign
+	// Body is the message body as rendered by listmonk.
+	Body string
+}
+
+// templateMessenger is a Messenger whose message bodies are rendered with a
+// template before being pushed, eg. to shorten them to an SMS.
+type templateMessenger struct {
+	messenger.Messenger
+	tpl *template.Template
+}
+
+// parseBodyTemplate parses a messenger's body template.
+func parseBodyTemplate(name, body string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(body)
+}
+
+// withTemplate returns m with bodies rendered with tpl, if set.
+func withTemplate(m messenger.Messenger, tpl *template.Template) messenger.Messenger {
+	if tpl == nil {
+		return m
+	}
+	return templateMessenger{Messenger: m, tpl: tpl}
+}
+
+func (t templateMessenger) Push(msg messenger.Message) error {
+	return t.PushContext(context.Background(), msg)
+}
+
+func (t templateMessenger) PushContext(ctx context.Context, msg messenger.Message) error {
+	var b bytes.Buffer
+	if err := t.tpl.Execute(&b, templateData{
+		Subscriber: msg.Subscriber,
+		Campaign:   msg.Campaign,
+		Body:       string(msg.Body),
+	}); err != nil {
+		return messenger.NewError(messenger.ErrContentRejected, fmt.Errorf("error rendering body template: %v", err))
+	}
+
+	msg.Body = b.Bytes()
+	return messenger.PushContext(ctx, t.Messenger, msg)
+}
+
+// truncate shortens s to at most n characters.
+func truncate(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+// stripHTML returns the text of an HTML document, with line breaks for
+// paragraphs and breaks and other whitespace collapsed.
+func stripHTML(s string) string {
+	s = reHTMLSkip.ReplaceAllString(s, "")
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(reHTMLTag.ReplaceAllString(s, ""))
+	s = reSpaces.ReplaceAllString(strings.ReplaceAll(s, "\u00a0", " "), " ")
+	return strings.TrimSpace(reBreaks.ReplaceAllString(s, "\n"))
+}
+
+// defaultValue returns def if v is missing or empty.
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	if rv := reflect.ValueOf(v); rv.IsZero() || (rv.Kind() == reflect.String && strings.TrimSpace(rv.String()) == "") {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/listmonk/models"
+)
+
+func TestTemplateMessenger(t *testing.T) {
+	tpl, err := parseBodyTemplate("sms", `Hi {{ .Subscriber.Attribs.nickname | default .Subscriber.Name }}, `+
+		`{{ with .Campaign }}{{ .Name | upper }}: {{ end }}{{ .Body | stripHTML | truncate 20 }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubMessenger{name: "twilio"}
+	m := withTemplate(stub, tpl)
+
+	msg := messenger.Message{
+		Body:       []byte(`<html><head><style>p {}</style></head><p>Big&nbsp;sale <b>today</b></p><p>Everything must go</p></html>`),
+		Subscriber: models.Subscriber{Name: "Anu", Attribs: models.SubscriberAttribs{"nickname": ""}},
+		Campaign:   &models.Campaign{Name: "Diwali"},
+	}
+	if err := m.Push(msg); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if want := "Hi Anu, DIWALI: Big sale today\nEvery"; string(stub.msgs[0].Body) != want {
+		t.Errorf("got %q, want %q", stub.msgs[0].Body, want)
+	}
+	if string(msg.Body) == string(stub.msgs[0].Body) {
+		t.Error("expected the original message to be left as is")
+	}
+}
+
+func TestTemplateMessengerNoCampaign(t *testing.T) {
+	// The example in the README and sample config.
+	tpl, err := parseBodyTemplate("sms", `{{ with .Campaign }}{{ .Name | upper }}: {{ end }}{{ .Body | stripHTML | truncate 140 }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubMessenger{name: "twilio"}
+	m := withTemplate(stub, tpl)
+	for _, c := range []struct {
+		camp *models.Campaign
+		want string
+	}{
+		{nil, "Your OTP is 1234"},
+		{&models.Campaign{Name: "Login"}, "LOGIN: Your OTP is 1234"},
+	} {
+		stub.msgs = nil
+		if err := m.Push(messenger.Message{Body: []byte("<p>Your OTP is 1234</p>"), Campaign: c.camp}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		if got := string(stub.msgs[0].Body); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestTemplateMessengerError(t *testing.T) {
+	tpl, err := parseBodyTemplate("sms", `{{ .Campaign.Name }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubMessenger{name: "twilio"}
+	err = withTemplate(stub, tpl).Push(messenger.Message{Body: []byte("hi")})
+	if messenger.Class(err) != messenger.ErrContentRejected {
+		t.Errorf("expected content rejected, got %v", err)
+	}
+	if stub.pushed() != 0 {
+		t.Error("expected nothing to be pushed")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	if got := truncate(3, "héllo"); got != "hél" {
+		t.Errorf("truncate: got %q", got)
+	}
+	if got := truncate(10, "hello"); got != "hello" {
+		t.Errorf("truncate: got %q", got)
+	}
+	for _, c := range []struct {
+		v    interface{}
+		want interface{}
+	}{
+		{nil, "x"}, {"", "x"}, {" ", "x"}, {0.0, "x"}, {"a", "a"}, {1.5, 1.5},
+	} {
+		if got := defaultValue("x", c.v); got != c.want {
+			t.Errorf("default %v: got %v, want %v", c.v, got, c.want)
+		}
+	}
+	if got := stripHTML("a<br/>b &amp; <!-- c --><script>d</script>e"); got != "a\nb & e" {
+		t.Errorf("stripHTML: got %q", got)
+	}
+}
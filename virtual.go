@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+// Virtual messenger types, which are made of other loaded messengers.
+const (
+	virtualFailover = "failover"
+	virtualFanout   = "fanout"
+)
+
+// breakerCfg is the circuit breaker config of a failover messenger.
+type breakerCfg struct {
+	Failures int           `koanf:"failures"`
+	Cooldown time.Duration `koanf:"cooldown"`
+}
+
+func isVirtual(typ string) bool {
+	return typ == virtualFailover || typ == virtualFanout
+}
+
+// newVirtual creates a virtual messenger out of its loaded members.
+func newVirtual(app *App, name string, cfg MessengerCfg) (messenger.Messenger, error) {
+	members := make([]messenger.Member, 0, len(cfg.Messengers))
+	for _, m := range cfg.Messengers {
+		msgr, ok := app.messengers[m]
+		if !ok {
+			return nil, fmt.Errorf("messenger %s is not loaded", m)
+		}
+		o := app.opts[m]
+		members = append(members, messenger.Member{Name: m, Messenger: withLimit(withTimeout(msgr, o.timeout), m, o.limiter)})
+	}
+
+	switch cfg.Type {
+	case virtualFailover:
+		return messenger.NewFailover(name, members, messenger.BreakerOpt{
+			Failures: cfg.CircuitBreaker.Failures,
+			Cooldown: cfg.CircuitBreaker.Cooldown,
+		}, app.logger)
+	case virtualFanout:
+		return messenger.NewFanout(name, members, cfg.Policy)
+	}
+
+	return nil, fmt.Errorf("unknown virtual messenger: %s", cfg.Type)
+}
+
+// timeoutMessenger is a Messenger whose pushes have a deadline, so that a
+// member of a virtual messenger keeps its own timeout.
+type timeoutMessenger struct {
+	messenger.Messenger
+	timeout time.Duration
+}
+
+// withTimeout returns m with a deadline on every push, if timeout is set.
+func withTimeout(m messenger.Messenger, timeout time.Duration) messenger.Messenger {
+	if timeout <= 0 {
+		return m
+	}
+	return timeoutMessenger{Messenger: m, timeout: timeout}
+}
+
+func (t timeoutMessenger) Push(msg messenger.Message) error {
+	return t.PushContext(context.Background(), msg)
+}
+
+func (t timeoutMessenger) PushContext(ctx context.Context, msg messenger.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return messenger.PushContext(ctx, t.Messenger, msg)
+}
+
+// limitedMessenger is a Messenger whose pushes wait for its rate limit, so
+// that a member of a virtual messenger keeps its own limit.
+type limitedMessenger struct {
+	messenger.Messenger
+	name string
+	lim  *rateLimiter
+}
+
+// withLimit returns m with every push held to the rate limit, if set.
+func withLimit(m messenger.Messenger, name string, lim *rateLimiter) messenger.Messenger {
+	if lim == nil {
+		return m
+	}
+	return limitedMessenger{Messenger: m, name: name, lim: lim}
+}
+
+func (l limitedMessenger) Push(msg messenger.Message) error {
+	return l.PushContext(context.Background(), msg)
+}
+
+func (l limitedMessenger) PushContext(ctx context.Context, msg messenger.Message) error {
+	waited, err := l.lim.wait(ctx)
+	if waited {
+		metricRateLimited.WithLabelValues(l.name).Inc()
+	}
+	if err != nil {
+		return err
+	}
+	return messenger.PushContext(ctx, l.Messenger, msg)
+}
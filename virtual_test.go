@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+)
+
+func TestNewVirtualFailover(t *testing.T) {
+	var (
+		twilio   = &stubMessenger{name: "twilio", errs: []error{messenger.NewError(messenger.ErrProviderOutage, errors.New("down"))}}
+		pinpoint = &stubMessenger{name: "pinpoint"}
+	)
+	app := &App{
+		logger:     onelog.New(os.Stderr, 0),
+		messengers: map[string]messenger.Messenger{"twilio": twilio, "pinpoint": pinpoint},
+		opts:       map[string]msgrOpts{"twilio": {timeout: 5 * time.Second}},
+	}
+
+	m, err := newVirtual(app, "sms", MessengerCfg{Type: virtualFailover, Messengers: []string{"twilio", "pinpoint"}})
+	if err != nil {
+		t.Fatalf("newVirtual: %v", err)
+	}
+	if err := m.Push(messenger.Message{}); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if twilio.pushed() != 1 || pinpoint.pushed() != 1 {
+		t.Fatalf("expected a push to each member, got %d and %d", twilio.pushed(), pinpoint.pushed())
+	}
+
+	if _, err := newVirtual(app, "sms", MessengerCfg{Type: virtualFailover, Messengers: []string{"sns"}}); err == nil {
+		t.Error("expected error for a member that isn't loaded")
+	}
+}
+
+func TestVirtualFailoverRateLimit(t *testing.T) {
+	twilio := &stubMessenger{name: "twilio"}
+	lim, err := newRateLimiter(rateLimitCfg{Rate: 10, Burst: 1, Mode: rateLimitReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		logger:     onelog.New(os.Stderr, 0),
+		messengers: map[string]messenger.Messenger{"twilio": twilio},
+		opts:       map[string]msgrOpts{"twilio": {limiter: lim}},
+	}
+
+	m, err := newVirtual(app, "sms", MessengerCfg{Type: virtualFailover, Messengers: []string{"twilio"}})
+	if err != nil {
+		t.Fatalf("newVirtual: %v", err)
+	}
+
+	// Members wait for their own limit, whatever its mode.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := m.Push(messenger.Message{}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("expected the pushes to wait ~200ms, took %v", d)
+	}
+	if twilio.pushed() != 3 {
+		t.Errorf("expected 3 pushes, got %d", twilio.pushed())
+	}
+}
+
+func TestPostbackFanout(t *testing.T) {
+	var (
+		ses    = &stubMessenger{name: "ses"}
+		twilio = &stubMessenger{name: "twilio", errs: []error{messenger.NewError(messenger.ErrInvalidRecipient, errors.New("no phone"))}}
+	)
+	app := &App{
+		logger:      onelog.New(os.Stderr, 0),
+		concurrency: 1,
+		messengers:  map[string]messenger.Messenger{"ses": ses, "twilio": twilio},
+		opts:        map[string]msgrOpts{},
+	}
+
+	m, err := newVirtual(app, "alerts", MessengerCfg{Type: virtualFanout, Messengers: []string{"ses", "twilio"}, Policy: messenger.FanoutAny})
+	if err != nil {
+		t.Fatalf("newVirtual: %v", err)
+	}
+	app.messengers["alerts"] = m
+
+	res, class := push(context.Background(), app, "alerts", m, messenger.Message{}, false)
+	if res.Status != statusSent || class != nil {
+		t.Fatalf("expected any policy to succeed, got %+v", res)
+	}
+	want := []channelResult{
+		{Messenger: "ses", Status: statusSent},
+		{Messenger: "twilio", Status: statusFailed, Error: "invalid recipient: no phone", Code: "invalid_recipient"},
+	}
+	if !reflect.DeepEqual(res.Channels, want) {
+		t.Fatalf("unexpected channels: %+v", res.Channels)
+	}
+}
+
+func TestVirtualFanoutRateLimit(t *testing.T) {
+	var (
+		ses    = &stubMessenger{name: "ses"}
+		twilio = &stubMessenger{name: "twilio"}
+	)
+	lim, err := newRateLimiter(rateLimitCfg{Rate: 10, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{
+		logger:     onelog.New(os.Stderr, 0),
+		messengers: map[string]messenger.Messenger{"ses": ses, "twilio": twilio},
+		opts:       map[string]msgrOpts{"twilio": {limiter: lim}},
+	}
+
+	m, err := newVirtual(app, "alerts", MessengerCfg{Type: virtualFanout, Messengers: []string{"ses", "twilio"}})
+	if err != nil {
+		t.Fatalf("newVirtual: %v", err)
+	}
+
+	// The limited channel holds back the fan-out, while the other isn't limited.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := m.Push(messenger.Message{}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("expected the pushes to wait ~200ms, took %v", d)
+	}
+	if ses.pushed() != 3 || twilio.pushed() != 3 {
+		t.Errorf("expected 3 pushes to each channel, got %d and %d", ses.pushed(), twilio.pushed())
+	}
+
+	// A cancelled wait fails the limited channel.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := messenger.PushContext(ctx, m, messenger.Message{}); err == nil {
+		t.Error("expected a cancelled push to fail")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
+)
+
+// startWorkers starts workers that drain q into every loaded messenger.
+func startWorkers(app *App, q *queue.Queue, workers int) {
+	var workerCtx, pushCtx context.Context
+	workerCtx, app.stopWorkers = context.WithCancel(context.Background())
+	pushCtx, app.abortPushes = context.WithCancel(context.Background())
+
+	for name, m := range app.messengers {
+		name, m := name, m
+		app.workers.Add(1)
+		go func() {
+			defer app.workers.Done()
+			q.Run(workerCtx, name, workers, func(j queue.Job) error {
+				res, _ := push(pushCtx, app, name, m, j.Message, false)
+
+				// Put back messages interrupted by a shutdown.
+				if res.Status == statusFailed && pushCtx.Err() != nil {
+					return pushCtx.Err()
+				}
+				return nil
+			})
+		}()
+	}
+}
+
+// stopWorkers waits for the queues of the loaded messengers to be drained and
+// stops the workers. Past ctx's deadline, the pushes in progress are aborted
+// and their messages put back in the queue.
+func stopWorkers(ctx context.Context, app *App) {
+	names := make([]string, 0, len(app.messengers))
+	for name := range app.messengers {
+		names = append(names, name)
+	}
+	if err := app.queue.Drain(ctx, names...); err != nil {
+		logger.Printf("queue not drained: %v", err)
+	}
+
+	// Let the workers finish their current push, aborting them past the
+	// deadline.
+	app.stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		app.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		app.abortPushes()
+		<-done
+	}
+}
+
+// closeMessengers flushes and then closes every loaded messenger.
+func closeMessengers(app *App) {
+	for name, m := range app.messengers {
+		if err := m.Flush(); err != nil {
+			logger.Printf("error flushing %s: %v", name, err)
+		}
+		if err := m.Close(); err != nil {
+			logger.Printf("error closing %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/francoispqt/onelog"
+	"github.com/joeirimpan/listmonk-messenger/internal/queue"
+	"github.com/joeirimpan/listmonk-messenger/messenger"
+	"github.com/knadh/listmonk/models"
+)
+
+// blockingMessenger is a messenger whose pushes block until their context is
+// done, and that records the calls made to it.
+type blockingMessenger struct {
+	started chan struct{}
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (b *blockingMessenger) record(call string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, call)
+}
+
+func (b *blockingMessenger) Name() string { return "blocking" }
+
+func (b *blockingMessenger) Push(msg messenger.Message) error {
+	return b.PushContext(context.Background(), msg)
+}
+
+func (b *blockingMessenger) PushContext(ctx context.Context, msg messenger.Message) error {
+	b.record("push")
+	b.started <- struct{}{}
+	<-ctx.Done()
+	b.record("abort")
+	return ctx.Err()
+}
+
+func (b *blockingMessenger) Flush() error { b.record("flush"); return nil }
+func (b *blockingMessenger) Close() error { b.record("close"); return nil }
+
+func newTestWorkers(t *testing.T, m messenger.Messenger, msgs ...messenger.Message) *App {
+	t.Helper()
+
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	if err := q.Push(m.Name(), msgs...); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	app := &App{
+		logger:     onelog.New(os.Stderr, 0),
+		messengers: map[string]messenger.Messenger{m.Name(): m},
+		opts:       map[string]msgrOpts{},
+		queue:      q,
+	}
+	startWorkers(app, q, 1)
+	return app
+}
+
+func testMessages(uuids ...string) []messenger.Message {
+	out := make([]messenger.Message, 0, len(uuids))
+	for _, u := range uuids {
+		out = append(out, messenger.Message{Body: []byte("hi"), Subscriber: models.Subscriber{UUID: u}})
+	}
+	return out
+}
+
+func TestStopWorkersDrains(t *testing.T) {
+	m := &stubMessenger{name: "sms"}
+	app := newTestWorkers(t, m, testMessages("a", "b", "c")...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopWorkers(ctx, app)
+
+	if m.pushed() != 3 {
+		t.Errorf("expected 3 pushes, got %d", m.pushed())
+	}
+	if n, _ := app.queue.Len("sms"); n != 0 {
+		t.Errorf("expected an empty queue, got %d", n)
+	}
+}
+
+func TestStopWorkersAbort(t *testing.T) {
+	m := &blockingMessenger{started: make(chan struct{}, 1)}
+	app := newTestWorkers(t, m, testMessages("a", "b")...)
+
+	select {
+	case <-m.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stopWorkers(ctx, app)
+	closeMessengers(app)
+
+	// The aborted message is put back along with the one never picked up.
+	if n, _ := app.queue.Len(m.Name()); n != 2 {
+		t.Errorf("expected 2 queued messages, got %d", n)
+	}
+
+	want := []string{"push", "abort", "flush", "close"}
+	if len(m.calls) != len(want) {
+		t.Fatalf("expected calls %v, got %v", want, m.calls)
+	}
+	for n, c := range want {
+		if m.calls[n] != c {
+			t.Errorf("expected calls %v, got %v", want, m.calls)
+			break
+		}
+	}
+}
+
+func TestStopServerAbort(t *testing.T) {
+	m := &blockingMessenger{started: make(chan struct{}, 1)}
+	app := &App{messengers: map[string]messenger.Messenger{m.Name(): m}}
+
+	srv := httptest.NewUnstartedServer(wrap(app, func(w http.ResponseWriter, r *http.Request) {
+		m.PushContext(r.Context(), testMessages("a")[0])
+	}))
+	baseCtx, abort := context.WithCancel(context.Background())
+	app.abortRequests = abort
+	srv.Config.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	srv.Start()
+	defer srv.Close()
+
+	go http.Get(srv.URL)
+	select {
+	case <-m.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stopServer(ctx, app, srv.Config)
+	closeMessengers(app)
+
+	// The messenger is closed only once the request's push has been aborted.
+	want := []string{"push", "abort", "flush", "close"}
+	if len(m.calls) != len(want) {
+		t.Fatalf("expected calls %v, got %v", want, m.calls)
+	}
+	for n, c := range want {
+		if m.calls[n] != c {
+			t.Errorf("expected calls %v, got %v", want, m.calls)
+			break
+		}
+	}
+}